@@ -0,0 +1,93 @@
+package jrpc2
+
+import (
+	"context"
+
+	"github.com/creachadair/jrpc2/code"
+)
+
+// A Pending is a call whose response may not yet have arrived, as returned by
+// (*Client).Start and by the invokers passed to a ClientInterceptor. A
+// Pending is safe for concurrent use by multiple goroutines.
+type Pending struct {
+	method string
+	rsp    *Response
+}
+
+// Start sends a single request and returns without waiting for its response.
+// The returned Pending reports when the response arrives, and may be used to
+// wait for it. Start blocks only until the request has been sent, so that a
+// caller may issue many calls on the same connection from a single goroutine:
+//
+//    var calls []*jrpc2.Pending
+//    for _, arg := range args {
+//       p, err := cli.Start(ctx, "Process", arg)
+//       if err != nil {
+//          log.Fatalf("Start failed: %v", err)
+//       }
+//       calls = append(calls, p)
+//    }
+//    for _, p := range calls {
+//       rsp, err := p.Wait()
+//       ...
+//    }
+//
+// If ctx ends before the response arrives, the call fails as (*Client).Call
+// does. The request passes through the client's interceptors, but it is not
+// retried if it fails, even if the client has a retry policy.
+func (c *Client) Start(ctx context.Context, method string, params interface{}) (*Pending, error) {
+	ps, err := c.invoke(ctx, []Spec{{Method: method, Params: params}})
+	if err != nil {
+		return nil, unwrapSend(err)
+	} else if len(ps) != 1 {
+		return nil, Errorf(code.InternalError, "got %d responses for call to %q", len(ps), method)
+	}
+	return ps[0], nil
+}
+
+// ID returns the request identifier of the call.
+func (p *Pending) ID() string { return p.rsp.id }
+
+// Method returns the name of the method called.
+func (p *Pending) Method() string { return p.method }
+
+// Done returns a channel that is closed when the response to the call has
+// arrived, or the call has failed or been cancelled. Once Done is closed,
+// Wait does not block.
+func (p *Pending) Done() <-chan struct{} { return p.rsp.done.ready }
+
+// OnDone arranges for f to be called with the response to the call once it
+// has arrived, or the call has failed or been cancelled. Errors are reported
+// by the Error method of the response, without the mapping to context errors
+// done by Wait. Hooks run in the order they were added, before Done is closed
+// and before Wait returns, so f must not wait for p. If the hooks for p have
+// already run, OnDone calls f directly.
+func (p *Pending) OnDone(f func(*Response)) { p.rsp.onDone(f) }
+
+// Wait blocks until the response to the call arrives, and reports it as
+// (*Client).Call does: A successful call reports a nil error and a non-nil
+// response, and errors from the server have concrete type *jrpc2.Error. It is
+// safe to call Wait multiple times; each call reports the same result.
+func (p *Pending) Wait() (*Response, error) {
+	p.rsp.wait()
+	if err := p.rsp.Error(); err != nil {
+		return nil, filterError(err)
+	}
+	return p.rsp, nil
+}
+
+// WaitResult invokes Wait. If the call succeeded, the result is decoded into
+// result, as (*Client).CallResult does.
+func (p *Pending) WaitResult(result interface{}) error {
+	rsp, err := p.Wait()
+	if err != nil {
+		return err
+	}
+	return rsp.UnmarshalResult(result)
+}
+
+// Cancel abandons the call if its response has not yet arrived. Wait then
+// reports context.Canceled for the call, and the client sends an rpc.cancel
+// notification to the server unless DisableCancel is set. Cancel has no
+// effect if the call is already complete.
+func (p *Pending) Cancel() { p.rsp.cancel() }
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/creachadair/jrpc2/channel"
 	"github.com/creachadair/jrpc2/code"
@@ -46,6 +47,16 @@ type Handler interface {
 	Handle(context.Context, *Request) (interface{}, error)
 }
 
+// An Interceptor wraps the invocation of a handler by a server. The
+// interceptor receives the context and request that would be passed to the
+// handler, along with the next handler in the chain. It may modify the context
+// before calling next, return a result without calling next at all, or
+// inspect and rewrite the result or error returned by next.
+//
+// The context passed to an interceptor includes the same values as the
+// context passed to the handler (see Handler).
+type Interceptor func(ctx context.Context, req *Request, next Handler) (interface{}, error)
+
 // A Request is a request message from a client to a server.
 type Request struct {
 	id     json.RawMessage // the request ID, nil for notifications
@@ -132,6 +143,16 @@ type Response struct {
 	// that at most one write is ever performed.
 	ch     chan *jmessage
 	cancel func()
+
+	// If set, the client runs the completion hooks for this request when its
+	// reply arrives (see finish).
+	done *completion
+
+	// If set, progress notifications for this request are delivered here.
+	progress func(string, json.RawMessage)
+
+	// If set, this request creates a subscription for this subscriber.
+	sub *Subscriber
 }
 
 // ID returns the request identifier for r.
@@ -167,28 +188,90 @@ func (r *Response) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// wait blocks until p is complete. It is safe to call this multiple times and
-// from concurrent goroutines.
+// wait blocks until p is complete, and its completion hooks (if any) have
+// run. It is safe to call this multiple times and from concurrent goroutines.
 func (r *Response) wait() {
+	r.receive()
+	if r.done != nil {
+		<-r.done.ready
+	}
+}
+
+// receive blocks until the reply for r has been received.
+func (r *Response) receive() {
 	raw, ok := <-r.ch
 	if ok {
-		// N.B. We intentionally DO NOT have the sender close the channel, to
-		// prevent a data race between callers of Wait. The channel is closed
-		// by the first waiter to get a real value (ok == true).
-		//
-		// The first waiter must update the response value, THEN close the
-		// channel and cancel the context. This order ensures that subsequent
-		// waiters all get the same response, and do not race on accessing it.
-		r.err = raw.E
-		r.result = raw.R
-		close(r.ch)
-		r.cancel() // release the context observer
-
-		// Sanity check: The response IDs should match. Do this after delivery so
-		// a failure does not orphan resources.
-		if id := string(fixID(raw.ID)); id != r.id {
-			panic(fmt.Sprintf("Mismatched response ID %q expecting %q", id, r.id))
-		}
+		r.complete(raw)
+	}
+}
+
+// post delivers raw as the reply for r to its waiters, and ends the context of
+// the request so that the client observer finishes it. The caller must ensure
+// post is called at most once for a given response.
+func (r *Response) post(raw *jmessage) {
+	r.ch <- raw
+	r.cancel()
+}
+
+// A completion records the hooks to run when the reply to a client request
+// arrives.
+type completion struct {
+	ready chan struct{} // closed after the hooks have run
+
+	mu    sync.Mutex
+	hooks []func(*Response)
+	fired bool // the hooks have been taken for execution
+}
+
+// onDone adds f to the completion hooks of r. If the hooks have already run,
+// onDone calls f directly once r is complete.
+func (r *Response) onDone(f func(*Response)) {
+	c := r.done
+	c.mu.Lock()
+	if !c.fired {
+		c.hooks = append(c.hooks, f)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	r.receive()
+	f(r)
+}
+
+// finish receives the reply for r, runs its completion hooks in order, and
+// then marks r ready.
+func (r *Response) finish() {
+	r.receive()
+	c := r.done
+	c.mu.Lock()
+	hooks := c.hooks
+	c.hooks, c.fired = nil, true
+	c.mu.Unlock()
+	for _, f := range hooks {
+		f(r)
+	}
+	close(c.ready)
+}
+
+// complete records raw as the reply for r. The caller must have received raw
+// from r.ch.
+func (r *Response) complete(raw *jmessage) {
+	// N.B. We intentionally DO NOT have the sender close the channel, to
+	// prevent a data race between callers of Wait. The channel is closed
+	// by the first waiter to get a real value (ok == true).
+	//
+	// The first waiter must update the response value, THEN close the
+	// channel and cancel the context. This order ensures that subsequent
+	// waiters all get the same response, and do not race on accessing it.
+	r.err = raw.E
+	r.result = raw.R
+	close(r.ch)
+	r.cancel() // release the context observer
+
+	// Sanity check: The response IDs should match. Do this after delivery so
+	// a failure does not orphan resources.
+	if id := string(fixID(raw.ID)); id != r.id {
+		panic(fmt.Sprintf("Mismatched response ID %q expecting %q", id, r.id))
 	}
 }
 
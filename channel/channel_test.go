@@ -1,6 +1,7 @@
 package channel
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -244,3 +245,59 @@ func TestWithTrigger(t *testing.T) {
 		})
 	}
 }
+
+func TestLimit(t *testing.T) {
+	const limit = 16
+	small := `["short"]`
+	large := `["` + strings.Repeat("long", 10) + `"]`
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cr, sw := io.Pipe()
+			sr, cw := io.Pipe()
+			lhs := test.framing(cr, cw)
+			rhs := Limit(test.framing, limit)(sr, sw)
+			defer lhs.Close()
+			defer rhs.Close()
+
+			// An oversized message is discarded with an error, after which the
+			// channel is still usable.
+			go func() {
+				for _, msg := range []string{large, small} {
+					if err := lhs.Send([]byte(msg)); err != nil {
+						t.Errorf("Send failed: %v", err)
+					}
+				}
+			}()
+			_, err := rhs.Recv()
+			if v, ok := err.(*MessageTooLargeError); !ok || v.Size != len(large) || v.Limit != limit {
+				t.Errorf("Recv large: got error %v, want size %d, limit %d", err, len(large), limit)
+			}
+			if msg, err := rhs.Recv(); err != nil {
+				t.Errorf("Recv small: unexpected error: %v", err)
+			} else if got := string(msg); got != small {
+				t.Errorf("Recv small: got %#q, want %#q", got, small)
+			}
+		})
+	}
+}
+
+// errChannel is a Channel whose Recv reports a fixed message and error.
+type errChannel struct {
+	Channel
+	msg []byte
+	err error
+}
+
+func (c errChannel) Recv() ([]byte, error) { return c.msg, c.err }
+
+func TestLimitError(t *testing.T) {
+	// An error from the underlying channel is reported even if the message
+	// received with it exceeds the limit.
+	want := errors.New("channel failed")
+	f := Limit(func(io.Reader, io.WriteCloser) Channel {
+		return errChannel{msg: []byte(strings.Repeat("x", 32)), err: want}
+	}, 16)
+	if _, err := f(nil, nil).Recv(); err != want {
+		t.Errorf("Recv: got error %v, want %v", err, want)
+	}
+}
@@ -29,6 +29,11 @@
 // Server Protocol (LSP) framing defined by
 // https://microsoft.github.io/language-server-protocol/specification.
 //
+// To bound the size of the messages a channel will accept, wrap its framing
+// with Limit:
+//
+//    ch := channel.Limit(channel.LSP, 1<<20)(r, wc)
+//
 package channel
 
 import "strings"
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 )
@@ -72,6 +73,7 @@ type hdr struct {
 	rd    *bufio.Reader
 	buf   *bytes.Buffer
 	rbuf  []byte
+	max   int // if positive, the maximum message size
 }
 
 // Send implements part of the Channel interface.
@@ -131,6 +133,11 @@ func (h *hdr) Recv() ([]byte, error) {
 	size, err := strconv.Atoi(contentLength)
 	if err != nil || size < 0 {
 		return nil, errors.New("invalid content-length")
+	} else if h.max > 0 && size > h.max {
+		if _, err := io.CopyN(ioutil.Discard, h.rd, int64(size)); err != nil {
+			return nil, err
+		}
+		return nil, &MessageTooLargeError{Size: size, Limit: h.max}
 	}
 
 	// We need to use ReadFull here because the buffered reader may not have a
@@ -150,6 +157,8 @@ func (h *hdr) Recv() ([]byte, error) {
 // Close implements part of the Channel interface.
 func (h *hdr) Close() error { return h.wc.Close() }
 
+func (h *hdr) setLimit(maxBytes int) { h.max = maxBytes }
+
 // Header returns a framing that behaves as StrictHeader, but allows received
 // messages to omit the Content-Type header without error. An error will still
 // be reported if a content-type is set but does not match.
@@ -0,0 +1,63 @@
+package channel
+
+import (
+	"fmt"
+	"io"
+)
+
+// Limit returns a framing that behaves as f, but whose channels do not accept
+// received messages longer than maxBytes. If maxBytes <= 0, Limit returns f
+// unchanged.
+//
+// When a message exceeds the limit, Recv discards it and reports an error of
+// concrete type *MessageTooLargeError; the channel remains usable for later
+// messages. For the framings defined in this package that encode the length
+// of a message before its content (Header, StrictHeader, LSP, Varint) or that
+// terminate each message with a delimiter (Line, Split), an oversized message
+// is discarded without being stored in memory. For other framings, including
+// RawJSON, the limit is checked after the message has been received.
+func Limit(f Framing, maxBytes int) Framing {
+	if maxBytes <= 0 {
+		return f
+	}
+	return func(r io.Reader, wc io.WriteCloser) Channel {
+		ch := f(r, wc)
+		if lim, ok := ch.(limiter); ok {
+			lim.setLimit(maxBytes)
+			return ch
+		}
+		return limited{Channel: ch, max: maxBytes}
+	}
+}
+
+// A MessageTooLargeError is reported by the Recv method of a channel with a
+// size limit (see Limit) for a message that exceeds the limit.
+type MessageTooLargeError struct {
+	Size, Limit int // the size of the message and the limit, in bytes
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large: %d bytes exceeds limit of %d", e.Size, e.Limit)
+}
+
+// A limiter is a Channel that can enforce a limit on message size itself.
+type limiter interface {
+	setLimit(maxBytes int)
+}
+
+// limited wraps a Channel to check the size of each message it receives.
+type limited struct {
+	Channel
+	max int
+}
+
+// Recv implements part of the Channel interface.
+func (c limited) Recv() ([]byte, error) {
+	msg, err := c.Channel.Recv()
+	if err != nil {
+		return msg, err
+	} else if len(msg) > c.max {
+		return nil, &MessageTooLargeError{Size: len(msg), Limit: c.max}
+	}
+	return msg, err
+}
@@ -16,7 +16,7 @@ var Line = Split('\n')
 // contain the split byte internally.
 func Split(b byte) Framing {
 	return func(r io.Reader, wc io.WriteCloser) Channel {
-		return split{split: b, wc: wc, buf: bufio.NewReader(r)}
+		return &split{split: b, wc: wc, buf: bufio.NewReader(r)}
 	}
 }
 
@@ -26,11 +26,12 @@ type split struct {
 	split byte
 	wc    io.WriteCloser
 	buf   *bufio.Reader
+	max   int // if positive, the maximum message size
 }
 
 // Send implements part of the Channel interface.  It reports an error if msg
 // contains a split byte.
-func (c split) Send(msg []byte) error {
+func (c *split) Send(msg []byte) error {
 	if bytes.ContainsAny(msg, string(c.split)) {
 		return errors.New("message contains split byte")
 	}
@@ -42,14 +43,24 @@ func (c split) Send(msg []byte) error {
 }
 
 // Recv implements part of the Channel interface.
-func (c split) Recv() ([]byte, error) {
+func (c *split) Recv() ([]byte, error) {
 	var buf bytes.Buffer
+	var size int // total bytes read, including any discarded
 	for {
 		chunk, err := c.buf.ReadSlice(c.split)
-		buf.Write(chunk)
+		size += len(chunk)
+		if c.max <= 0 || size <= c.max+1 {
+			buf.Write(chunk) // N.B. allow for the split byte
+		}
 		if err == bufio.ErrBufferFull {
 			continue // incomplete line
 		}
+		if n := size - 1; c.max > 0 && n > c.max {
+			if err != nil {
+				return nil, err
+			}
+			return nil, &MessageTooLargeError{Size: n, Limit: c.max}
+		}
 		line := buf.Bytes()
 		if n := len(line) - 1; n >= 0 {
 			return line[:n], err
@@ -59,4 +70,6 @@ func (c split) Recv() ([]byte, error) {
 }
 
 // Close implements part of the Channel interface.
-func (c split) Close() error { return c.wc.Close() }
+func (c *split) Close() error { return c.wc.Close() }
+
+func (c *split) setLimit(maxBytes int) { c.max = maxBytes }
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 )
 
 // Varint is a framing that transmits and receives messages on r and wc, with
@@ -23,6 +24,7 @@ type varint struct {
 	wc  io.WriteCloser
 	rd  *bufio.Reader
 	buf *bytes.Buffer
+	max int // if positive, the maximum message size
 }
 
 // Send implements part of the Channel interface. It encodes len(msg) as a
@@ -46,6 +48,11 @@ func (v *varint) Recv() ([]byte, error) {
 	ln, err := v.decode()
 	if err != nil {
 		return nil, err
+	} else if v.max > 0 && ln > v.max {
+		if _, err := io.CopyN(ioutil.Discard, v.rd, int64(ln)); err != nil {
+			return nil, err
+		}
+		return nil, &MessageTooLargeError{Size: ln, Limit: v.max}
 	}
 	out := make([]byte, ln)
 	nr, err := io.ReadFull(v.rd, out)
@@ -55,6 +62,8 @@ func (v *varint) Recv() ([]byte, error) {
 // Close implements part of the Channel interface.
 func (v *varint) Close() error { return v.wc.Close() }
 
+func (v *varint) setLimit(maxBytes int) { v.max = maxBytes }
+
 func (v *varint) decode() (int, error) {
 	ln, err := binary.ReadUvarint(v.rd)
 	if err != nil {
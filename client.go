@@ -11,6 +11,7 @@ import (
 
 	"github.com/creachadair/jrpc2/channel"
 	"github.com/creachadair/jrpc2/code"
+	"golang.org/x/sync/semaphore"
 )
 
 // A Client is a JSON-RPC 2.0 client. The client sends requests and receives
@@ -20,8 +21,10 @@ type Client struct {
 
 	log   func(string, ...interface{}) // write debug logs here
 	enctx encoder
-	snote func(*jmessage)
-	scall func(*jmessage) ([]byte, error)
+	snote func(context.Context, *jmessage)
+	scall func(context.Context, *jmessage) ([]byte, error)
+	retry *RetryPolicy // if non-nil, the policy for retrying calls
+	invoke Invoker     // sends requests through the client interceptors
 
 	allow1 bool // tolerate v1 replies with no version marker
 	allowC bool // send rpc.cancel when a request context ends
@@ -31,6 +34,15 @@ type Client struct {
 	err     error                // error from a previous operation
 	pending map[string]*Response // requests pending completion, by ID
 	nextID  int64                // next unused request ID
+
+	subs map[string]*Subscriber // active subscriptions, by ID
+
+	// Concurrent handling of server requests (see CallbackConcurrency).
+	cbsem  *semaphore.Weighted           // bounds active callbacks; nil if synchronous
+	cbctx  context.Context               // parent context for callbacks
+	cbstop context.CancelFunc            // cancels all callbacks at shutdown
+	cbwg   sync.WaitGroup                // running callback goroutines
+	cbs    map[string]context.CancelFunc // running callbacks, by request ID
 }
 
 // NewClient returns a new client that communicates with the server via ch.
@@ -43,15 +55,23 @@ func NewClient(ch channel.Channel, opts *ClientOptions) *Client {
 		enctx:  opts.encodeContext(),
 		snote:  opts.handleNotification(),
 		scall:  opts.handleCallback(),
+		retry:  opts.retryPolicy(),
 
 		// Lock-protected fields
 		ch:      ch,
 		pending: make(map[string]*Response),
 		nextID:  1,
+		subs:    make(map[string]*Subscriber),
+		cbs:     make(map[string]context.CancelFunc),
 
 		// Note that we start the ID counter at 1 here to avoid issues with a
 		// server implementation that treats 0 as equivalent to null.
 	}
+	c.invoke = c.intercept(opts.interceptors())
+	c.cbctx, c.cbstop = context.WithCancel(context.Background())
+	if n := opts.callbackConcurrency(); n > 0 {
+		c.cbsem = semaphore.NewWeighted(int64(n))
+	}
 
 	// The main client loop reads responses from the server and delivers them
 	// back to pending requests by their ID. Outbound requests do not queue;
@@ -93,24 +113,124 @@ func (c *Client) accept(ch channel.Receiver) error {
 }
 
 // handleRequest handles a callback or notification from the server. The
-// caller must hold c.mu, and this blocks until the handler completes.
+// caller must hold c.mu. Unless callbacks are concurrent, this blocks until
+// the handler completes.
 // Precondition: msg is a request or notification, not a response or error.
 func (c *Client) handleRequest(msg *jmessage) {
 	if msg.isNotification() {
-		if c.snote == nil {
+		if c.handleProgress(msg) {
+			return // delivered to a progress hook
+		} else if c.handleEvent(msg) {
+			return // delivered to a subscriber
+		} else if c.handleCancel(msg) {
+			return // cancelled running callbacks
+		} else if c.snote == nil {
 			c.log("Discarding notification: %v", msg)
-		} else {
-			c.snote(msg)
+			return
 		}
 	} else if c.scall == nil {
 		c.log("Discarding callback request: %v", msg)
-	} else if bits, err := c.scall(msg); err != nil {
+		return
+	}
+	if c.cbsem == nil {
+		c.sendReply(msg, c.runRequest(c.cbctx, msg))
+		return
+	}
+
+	id := string(msg.ID)
+	ctx, cancel := context.WithCancel(c.cbctx)
+	if !msg.isNotification() {
+		c.cbs[id] = cancel
+	}
+	c.cbwg.Add(1)
+	go func() {
+		defer c.cbwg.Done()
+		defer cancel()
+
+		var reply []byte
+		if err := c.cbsem.Acquire(ctx, 1); err != nil {
+			c.log("Callback for %v abandoned: %v", msg, err)
+		} else {
+			reply = c.runRequest(ctx, msg)
+			c.cbsem.Release(1)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !msg.isNotification() {
+			delete(c.cbs, id)
+		}
+		if ctx.Err() == nil && c.ch != nil {
+			c.sendReply(msg, reply)
+		}
+	}()
+}
+
+// runRequest invokes the handler for a callback or notification from the
+// server, and returns the encoded reply to send, if any.
+func (c *Client) runRequest(ctx context.Context, msg *jmessage) []byte {
+	if msg.isNotification() {
+		c.snote(ctx, msg)
+		return nil
+	}
+	bits, err := c.scall(ctx, msg)
+	if err != nil {
 		c.log("Callback for %v failed: %v", msg, err)
-	} else if err := c.ch.Send(bits); err != nil {
+		return nil
+	}
+	return bits
+}
+
+// sendReply sends the reply to a callback from the server, if there is one.
+// The caller must hold c.mu.
+func (c *Client) sendReply(msg *jmessage, reply []byte) {
+	if reply == nil {
+		return
+	} else if err := c.ch.Send(reply); err != nil {
 		c.log("Sending reply for callback %v failed: %v", msg, err)
 	}
 }
 
+// handleCancel handles an rpc.cancel notification from the server, by
+// cancelling the contexts of the running callbacks it names, and reports
+// whether it did so. Cancellations are handled only for concurrent callbacks.
+// The caller must hold c.mu.
+func (c *Client) handleCancel(msg *jmessage) bool {
+	if msg.M != rpcCancel || c.cbsem == nil {
+		return false
+	}
+	var ids []json.RawMessage
+	if err := json.Unmarshal(msg.P, &ids); err != nil {
+		return false
+	}
+	for _, id := range ids {
+		if cancel, ok := c.cbs[string(id)]; ok {
+			c.log("Cancelling callback for id %q", string(id))
+			cancel()
+		}
+	}
+	return true
+}
+
+// handleProgress delivers an rpc.progress notification from the server to the
+// progress hook of the pending request it names, if there is one, and reports
+// whether it did so. The caller must hold c.mu.
+func (c *Client) handleProgress(msg *jmessage) bool {
+	if msg.M != rpcProgress {
+		return false
+	}
+	var prog progressParams
+	if err := json.Unmarshal(msg.P, &prog); err != nil {
+		return false
+	}
+	id := string(fixID(prog.ID))
+	if p := c.pending[id]; p != nil && p.progress != nil {
+		p.progress(id, prog.Value)
+		return true
+	}
+	return false
+}
+
 // For each response, find the request pending on its ID and deliver it.  The
 // caller must hold c.mu.  Unknown response IDs are logged and discarded.  As
 // we are under the lock, we do not wait for the pending receiver to pick up
@@ -127,19 +247,22 @@ func (c *Client) deliver(rsp *jmessage) {
 		c.log("Discarding response for unknown ID %q", id)
 	} else if !c.versionOK(rsp.V) {
 		delete(c.pending, id)
-		p.ch <- &jmessage{
+		p.post(&jmessage{
 			ID: rsp.ID,
 			E: &Error{
 				code:    code.InvalidRequest,
 				message: fmt.Sprintf("incorrect version marker %q", rsp.V),
 			},
-		}
+		})
 		c.log("Invalid response for ID %q", id)
 	} else {
 		// Remove the pending request from the set and deliver its response.
 		// Determining whether it's an error is the caller's responsibility.
 		delete(c.pending, id)
-		p.ch <- rsp
+		if p.sub != nil {
+			c.registerSubscriber(p.sub, rsp)
+		}
+		p.post(rsp)
 		c.log("Completed request for ID %q", id)
 	}
 }
@@ -207,11 +330,11 @@ func (c *Client) send(ctx context.Context, reqs jmessages) ([]*Response, error)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.err != nil {
-		return nil, c.err
+		return nil, &sendError{c.err}
 	}
 	c.log("Outgoing batch: %s", string(b))
 	if err := c.ch.Send(b); err != nil {
-		return nil, err
+		return nil, &sendError{err}
 	}
 
 	// Now that we have sent them, record the requests for which we are awaiting
@@ -227,13 +350,15 @@ func (c *Client) send(ctx context.Context, reqs jmessages) ([]*Response, error)
 // waitComplete waits for completion of the context governing p. When the
 // context ends, check whether the request is still in the pending set for the
 // client: If so, a reply has not yet been delivered.  Otherwise, the
-// cancellation is a no-op ("too late").
+// cancellation is a no-op ("too late"). The context also ends when the reply
+// is delivered. In either case, finish p, running its completion hooks.
 func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 	<-pctx.Done()
 	cleanup := func() {}
 	c.mu.Lock()
 	defer func() {
 		c.mu.Unlock()
+		p.finish()
 		cleanup() // N.B. outside the lock
 	}()
 
@@ -252,10 +377,10 @@ func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 		jerr = &Error{code: code.FromError(err), message: err.Error()}
 	}
 
-	p.ch <- &jmessage{
+	p.post(&jmessage{
 		ID: json.RawMessage(id),
 		E:  jerr,
-	}
+	})
 
 	// Inform the server, best effort only. N.B. Use a background context here,
 	// as the original context has ended by the time we get here.
@@ -269,7 +394,8 @@ func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 
 // Call initiates a single request and blocks until the response returns.
 // A successful call reports a nil error and a non-nil response. Errors from
-// the server have concrete type *jrpc2.Error.
+// the server have concrete type *jrpc2.Error. If the client has a retry policy
+// (see ClientOptions), a failed call may be issued again.
 //
 //    rsp, err := c.Call(ctx, method, params)
 //    if e, ok := err.(*jrpc2.Error); ok {
@@ -280,19 +406,42 @@ func (c *Client) waitComplete(pctx context.Context, id string, p *Response) {
 //    handleValidResponse(rsp)
 //
 func (c *Client) Call(ctx context.Context, method string, params interface{}) (*Response, error) {
-	req, err := c.req(ctx, method, params)
-	if err != nil {
-		return nil, err
+	return callResult(c.callRetry(ctx, method, params))
+}
+
+// callRetry issues a single request, retrying it as described by the retry
+// policy of c, and blocks until the response returns. It reports errors as
+// call does.
+func (c *Client) callRetry(ctx context.Context, method string, params interface{}) (*Response, error) {
+	if c.retry == nil {
+		return c.call(ctx, method, params)
 	}
-	rsp, err := c.send(ctx, jmessages{req})
+	return c.retry.call(ctx, method, c.log, c.stopped, func() (*Response, error) {
+		return c.call(ctx, method, params)
+	})
+}
+
+// call issues a single request and blocks until the response returns. An
+// error reported by the server is recorded in the response; a non-nil error
+// means the request was not issued.
+func (c *Client) call(ctx context.Context, method string, params interface{}) (*Response, error) {
+	rsps, err := c.batch(ctx, []Spec{{Method: method, Params: params}})
 	if err != nil {
 		return nil, err
+	} else if len(rsps) != 1 {
+		return nil, Errorf(code.InternalError, "got %d responses for call to %q", len(rsps), method)
 	}
-	rsp[0].wait()
-	if err := rsp[0].Error(); err != nil {
+	return rsps[0], nil
+}
+
+// callResult converts the outcome of call to the form reported by Call.
+func callResult(rsp *Response, err error) (*Response, error) {
+	if err != nil {
+		return nil, unwrapSend(err)
+	} else if err := rsp.Error(); err != nil {
 		return nil, filterError(err)
 	}
-	return rsp[0], nil
+	return rsp, nil
 }
 
 // CallResult invokes Call with the given method and params. If it succeeds,
@@ -313,6 +462,32 @@ func (c *Client) CallResult(ctx context.Context, method string, params, result i
 // Any error returned is from sending the batch; the caller must check each
 // response for errors from the server.
 func (c *Client) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
+	rsps, err := c.batch(ctx, specs)
+	if err != nil {
+		return nil, unwrapSend(err)
+	}
+	return rsps, nil
+}
+
+// batch sends the requests described by specs through the interceptors of c,
+// and blocks until all the responses return.
+func (c *Client) batch(ctx context.Context, specs []Spec) ([]*Response, error) {
+	ps, err := c.invoke(ctx, specs)
+	if err != nil {
+		return nil, err
+	}
+	rsps := make([]*Response, len(ps))
+	for i, p := range ps {
+		p.rsp.wait()
+		rsps[i] = p.rsp
+	}
+	return rsps, nil
+}
+
+// start sends the requests described by specs, and returns a Pending for each
+// request that expects a reply. It is the innermost invoker of the
+// interceptor chain.
+func (c *Client) start(ctx context.Context, specs []Spec) ([]*Pending, error) {
 	reqs := make(jmessages, len(specs))
 	for i, spec := range specs {
 		if spec.Notify {
@@ -331,10 +506,17 @@ func (c *Client) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	for _, rsp := range rsps {
-		rsp.wait()
+	ps := make([]*Pending, len(rsps))
+	for i, rsp := range rsps {
+		ps[i] = &Pending{rsp: rsp}
 	}
-	return rsps, nil
+	for i, j := 0, 0; i < len(reqs); i++ {
+		if reqs[i].ID != nil {
+			ps[j].method = reqs[i].M
+			j++
+		}
+	}
+	return ps, nil
 }
 
 // A Spec combines a method name and parameter value. If the Notify field is
@@ -348,12 +530,41 @@ type Spec struct {
 // Notify transmits a notification to the specified method and parameters.  It
 // blocks until the notification has been sent.
 func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
-	req, err := c.note(ctx, method, params)
-	if err != nil {
-		return err
+	_, err := c.batch(ctx, []Spec{{Method: method, Params: params, Notify: true}})
+	return unwrapSend(err)
+}
+
+// A ClientInterceptor wraps the requests and notifications sent by a client.
+// The interceptor receives the context and the specs for the messages to be
+// sent, along with the next invoker in the chain. It may modify the context or
+// the specs before calling next, or fail without calling next at all.
+//
+// Call, Start, and Notify pass a single spec through the interceptors, and
+// Batch passes the specs of the whole batch. The next invoker returns once the
+// messages are sent, with a Pending for each request that expects a reply, and
+// the error it returns reports a failure to send the messages. To observe the
+// responses, use the OnDone method of each Pending: The hooks it registers run
+// before the caller of Call or Batch receives the responses, but do not delay
+// a caller of Start. Each attempt at a call retried by the client's retry
+// policy passes through the interceptors separately.
+type ClientInterceptor func(ctx context.Context, specs []Spec, next Invoker) ([]*Pending, error)
+
+// An Invoker sends the messages described by specs, and returns a Pending for
+// each request that expects a reply, in the same order as the specs and
+// omitting notifications. An Invoker does not wait for the replies.
+type Invoker func(ctx context.Context, specs []Spec) ([]*Pending, error)
+
+// intercept returns an Invoker that passes messages through icept before
+// sending them.
+func (c *Client) intercept(icept []ClientInterceptor) Invoker {
+	next := Invoker(c.start)
+	for i := len(icept) - 1; i >= 0; i-- {
+		f, inner := icept[i], next
+		next = func(ctx context.Context, specs []Spec) ([]*Pending, error) {
+			return f(ctx, specs, inner)
+		}
 	}
-	_, err = c.send(ctx, jmessages{req})
-	return err
+	return next
 }
 
 // Close shuts down the client, abandoning any pending in-flight requests.
@@ -362,6 +573,7 @@ func (c *Client) Close() error {
 	c.stop(errClientStopped)
 	c.mu.Unlock()
 	<-c.done
+	c.cbwg.Wait()
 	// Don't remark on a closed channel or EOF as a noteworthy failure.
 	if isUninteresting(c.err) {
 		return nil
@@ -369,6 +581,29 @@ func (c *Client) Close() error {
 	return c.err
 }
 
+// A sendError reports that requests could not be sent to the server, because
+// the channel failed or the client has stopped. Errors in preparing requests
+// are reported directly.
+type sendError struct{ err error }
+
+func (e *sendError) Error() string { return e.err.Error() }
+func (e *sendError) Unwrap() error { return e.err }
+
+// isSendError reports whether err is or wraps a *sendError.
+func isSendError(err error) bool {
+	var e *sendError
+	return errors.As(err, &e)
+}
+
+// unwrapSend returns the underlying error if err is a *sendError, otherwise
+// it returns err unmodified.
+func unwrapSend(err error) error {
+	if e, ok := err.(*sendError); ok {
+		return e.err
+	}
+	return err
+}
+
 func isUninteresting(err error) bool {
 	return err == io.EOF || channel.IsErrClosing(err) || err == errClientStopped
 }
@@ -386,6 +621,15 @@ func (c *Client) stop(err error) {
 	for _, p := range c.pending {
 		p.cancel()
 	}
+
+	// End any active subscriptions.
+	for id, sub := range c.subs {
+		sub.finish()
+		delete(c.subs, id)
+	}
+
+	// Cancel any running callbacks.
+	c.cbstop()
 	c.err = err
 	c.ch = nil
 }
@@ -423,9 +667,17 @@ func newPending(ctx context.Context, id string) (context.Context, *Response) {
 	// Buffer the channel so the response reader does not need to rendezvous
 	// with the recipient.
 	pctx, cancel := context.WithCancel(ctx)
-	return pctx, &Response{
+	rsp := &Response{
 		ch:     make(chan *jmessage, 1),
 		id:     id,
 		cancel: cancel,
+		done:   &completion{ready: make(chan struct{})},
+	}
+	if f, ok := ctx.Value(progressKey{}).(func(string, json.RawMessage)); ok {
+		rsp.progress = f
+	}
+	if sub, ok := ctx.Value(subscriberKey{}).(*Subscriber); ok {
+		rsp.sub = sub
 	}
+	return pctx, rsp
 }
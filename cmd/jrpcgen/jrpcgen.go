@@ -0,0 +1,627 @@
+// Program jrpcgen generates typed client stubs for a JSON-RPC service.
+//
+// Usage:
+//    jrpcgen [options] -type <T> [<dir>]
+//
+// The service type T is declared in the Go package in dir (default "."), and
+// its methods have the shapes accepted by handler.NewService. The output is a
+// Go source file declaring a client type with one method for each method of
+// the service, each of which calls the corresponding method via a
+// *jrpc2.Client.
+//
+// To use jrpcgen with "go generate", add a directive before the declaration
+// of the service type:
+//
+//    //go:generate jrpcgen -service Math
+//    type Math struct{}
+//
+// When -type is not set and jrpcgen is run by "go generate", the service type
+// is the first type declared after the directive.
+//
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/jrpc2/handler"
+)
+
+var (
+	typeName    = flag.String("type", "", "Name of the service type (required unless run by go generate)")
+	serviceName = flag.String("service", "", "Service name prefix for method names (Service.Method)")
+	namingStyle = flag.String("naming", "", `Method naming convention ("camel" or "snake"; default Go names)`)
+	clientName  = flag.String("client", "", "Name of the generated client type (default <type>Client)")
+	outputPath  = flag.String("output", "", `Output file path (default <dir>/<type>_client.go; "-" for stdout)`)
+	packageName = flag.String("package", "", "Package name for the generated file (default is the package of the service)")
+	importPath  = flag.String("import", "", "Import path of the service package (required if -package differs)")
+	usePointer  = flag.Bool("pointer", false, "Include the methods of *T, for a service constructed from a pointer")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: %[1]s [options] -type <T> [<dir>]
+
+Generate a typed client for the methods of the service type T declared in the
+Go package in dir (default "."). Each exported method of T having one of the
+signatures accepted by handler.New becomes a method of the client, which calls
+the corresponding JSON-RPC method through a *jrpc2.Client. Methods with pointer
+receivers are included only if -pointer is set, since handler.NewService does
+not export them for a service of type T. Methods whose parameter type does not
+encode as a JSON array or object, as the parameters of a request must, are
+skipped with a warning.
+
+If -service is set, the JSON-RPC method names have the form Service.Method, as
+for a service exported by a handler.ServiceMap. Otherwise the method names are
+the names of the Go methods, as for handler.NewService. The -naming flag selects
+the naming convention of handler.LowerCamelCase ("camel") or handler.SnakeCase
+("snake") instead, for services constructed by handler.Service.
+
+By default the client is generated in the package of the service. To generate
+it in another package, set -package and -import; types declared in the service
+package are then qualified by its name.
+
+When run by "go generate" without -type, the service type is the first type
+declared after the go:generate directive.
+
+Options:
+`, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("jrpcgen: ")
+
+	dir := "."
+	if flag.NArg() > 1 {
+		log.Fatal("At most one package directory may be given")
+	} else if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+
+	pkg, err := loadPackage(dir)
+	if err != nil {
+		log.Fatalf("Loading package: %v", err)
+	}
+	if *typeName == "" {
+		*typeName, err = pkg.generateTarget(os.Getenv("GOFILE"), os.Getenv("GOLINE"))
+		if err != nil {
+			log.Fatalf("Finding service type: %v", err)
+		}
+	}
+	if *packageName != "" && *packageName != pkg.name && *importPath == "" {
+		log.Fatal("You must set -import to generate a client in another package")
+	}
+
+	g := &generator{
+		pkg:     pkg,
+		service: *serviceName,
+		client:  *clientName,
+		naming:  func(name string) string { return name },
+		pointer: *usePointer,
+		imports: make(map[string]string),
+	}
+	switch *namingStyle {
+	case "":
+	case "camel":
+		g.naming = handler.LowerCamelCase
+	case "snake":
+		g.naming = handler.SnakeCase
+	default:
+		log.Fatalf("Unknown naming convention %q", *namingStyle)
+	}
+	if g.client == "" {
+		g.client = *typeName + "Client"
+	}
+	g.outPkg = pkg.name
+	if *packageName != "" && *packageName != pkg.name {
+		g.outPkg = *packageName
+		g.qualifier = pkg.name
+		g.imports[pkg.name] = *importPath
+	}
+	src, err := g.generate(*typeName)
+	if err != nil {
+		log.Fatalf("Generating client for %s: %v", *typeName, err)
+	}
+	for _, msg := range g.skipped {
+		log.Printf("Skipping method %s", msg)
+	}
+
+	out := *outputPath
+	if out == "" {
+		out = filepath.Join(dir, strings.ToLower(*typeName)+"_client.go")
+	}
+	if out == "-" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(out, src, 0644)
+	}
+	if err != nil {
+		log.Fatalf("Writing output: %v", err)
+	}
+}
+
+// A goPackage is the parsed source of a Go package.
+type goPackage struct {
+	name  string
+	fset  *token.FileSet
+	files map[string]*ast.File // keyed by file path
+	info  *types.Info          // the types of expressions, where known
+}
+
+// loadPackage parses the non-test Go source files in dir. It is an error if
+// the directory contains more than one package. The package is type-checked,
+// but type errors are not reported, so that as much as possible of the
+// package is resolved.
+func loadPackage(dir string) (*goPackage, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	} else if len(pkgs) != 1 {
+		return nil, fmt.Errorf("found %d packages in %q, want 1", len(pkgs), dir)
+	}
+	for name, p := range pkgs {
+		var files []*ast.File
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+		info := &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Uses:  make(map[*ast.Ident]types.Object),
+		}
+		conf := types.Config{
+			Importer: importer.ForCompiler(fset, "source", nil),
+			Error:    func(error) {},
+		}
+		conf.Check(name, fset, files, info)
+		return &goPackage{name: name, fset: fset, files: p.Files, info: info}, nil
+	}
+	panic("unreachable")
+}
+
+// generateTarget returns the name of the first type declared after the given
+// line of the named file, as set by "go generate" in $GOFILE and $GOLINE.
+func (p *goPackage) generateTarget(file, line string) (string, error) {
+	if file == "" || line == "" {
+		return "", errors.New("no -type specified")
+	}
+	lnum, err := strconv.Atoi(line)
+	if err != nil {
+		return "", fmt.Errorf("invalid line number %q", line)
+	}
+	for path, f := range p.files {
+		if filepath.Base(path) != file {
+			continue
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE || p.fset.Position(gd.Pos()).Line < lnum {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				return spec.(*ast.TypeSpec).Name.Name, nil
+			}
+		}
+		return "", fmt.Errorf("no type declared after %s:%d", file, lnum)
+	}
+	return "", fmt.Errorf("file %q not found", file)
+}
+
+// A generator constructs the source for a client type.
+type generator struct {
+	pkg       *goPackage
+	service   string // the service name prefix, if any
+	client    string // the name of the client type
+	naming    func(string) string
+	outPkg    string // the package name of the output
+	qualifier string // if set, qualify local types with this package name
+	pointer   bool   // include methods with pointer receivers
+
+	imports map[string]string // package name → import path
+	buf     bytes.Buffer      // the declarations of the output
+	skipped []string          // the methods skipped, with the reason
+}
+
+// A method is a service method for which a client method is generated.
+type method struct {
+	name    string
+	doc     *ast.CommentGroup
+	param   ast.Expr // the parameter type, or nil if none
+	request bool     // the parameter is a *jrpc2.Request
+	result  ast.Expr // the result type, or nil if only error
+	imports map[string]string
+}
+
+func (g *generator) printf(msg string, args ...interface{}) { fmt.Fprintf(&g.buf, msg, args...) }
+
+// generate returns the formatted source of a client for the named type.
+func (g *generator) generate(typeName string) ([]byte, error) {
+	all, err := g.pkg.methodsOf(typeName, g.pointer)
+	if err != nil {
+		return nil, err
+	}
+	var methods []*method
+	for _, m := range all {
+		if m.param != nil && !m.request && !g.pkg.isComposite(m.param) {
+			g.skipped = append(g.skipped, fmt.Sprintf("%s: parameter type %s does not encode as an array or object",
+				m.name, types.ExprString(m.param)))
+			continue
+		}
+		methods = append(methods, m)
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no matching exported methods")
+	}
+
+	target := g.service
+	if target == "" {
+		target = typeName
+	}
+	g.printf("// %[1]s is a client for the methods of the %[2]s service.\n", g.client, target)
+	g.printf("type %s struct{ cli *jrpc2.Client }\n\n", g.client)
+	g.printf("// New%[1]s returns a %[1]s that issues calls through cli.\n", g.client)
+	g.printf("func New%[1]s(cli *jrpc2.Client) *%[1]s { return &%[1]s{cli: cli} }\n", g.client)
+	for _, m := range methods {
+		if err := g.method(m); err != nil {
+			return nil, fmt.Errorf("method %s: %v", m.name, err)
+		}
+	}
+
+	g.imports["context"] = "context"
+	g.imports["jrpc2"] = "github.com/creachadair/jrpc2"
+	names := make([]string, 0, len(g.imports))
+	for name := range g.imports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := g.imports[names[i]], g.imports[names[j]]
+		if si, sj := isStdlib(pi), isStdlib(pj); si != sj {
+			return si // standard library packages first
+		}
+		return pi < pj
+	})
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by jrpcgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", g.outPkg)
+	for i, name := range names {
+		ipath := g.imports[name]
+		if i > 0 && isStdlib(g.imports[names[i-1]]) && !isStdlib(ipath) {
+			src.WriteString("\n")
+		}
+		if path.Base(ipath) == name {
+			fmt.Fprintf(&src, "\t%q\n", ipath)
+		} else {
+			fmt.Fprintf(&src, "\t%s %q\n", name, ipath)
+		}
+	}
+	fmt.Fprintf(&src, ")\n\n")
+	g.buf.WriteTo(&src)
+	return format.Source(src.Bytes())
+}
+
+// isStdlib reports whether ipath is the import path of a standard library
+// package, whose first path element does not contain a dot.
+func isStdlib(ipath string) bool {
+	return !strings.Contains(strings.SplitN(ipath, "/", 2)[0], ".")
+}
+
+// method generates a client method for m.
+func (g *generator) method(m *method) error {
+	for name, ipath := range m.imports {
+		if old, ok := g.imports[name]; ok && old != ipath {
+			return fmt.Errorf("conflicting imports for %q", name)
+		}
+		g.imports[name] = ipath
+	}
+	rpcName := g.naming(m.name)
+	if g.service != "" {
+		rpcName = g.service + "." + rpcName
+	}
+
+	g.printf("\n// %s calls the %q method.\n", m.name, rpcName)
+	if m.doc != nil {
+		g.printf("//\n")
+		for _, line := range strings.Split(strings.TrimSpace(m.doc.Text()), "\n") {
+			g.printf("// %s\n", line)
+		}
+	}
+	g.printf("func (c *%s) %s(ctx context.Context", g.client, m.name)
+	params := "nil"
+	if m.request {
+		g.printf(", params interface{}")
+		params = "params"
+	} else if m.param != nil {
+		ptype, err := g.typeString(m.param)
+		if err != nil {
+			return err
+		}
+		g.printf(", params %s", ptype)
+		params = "params"
+	}
+
+	if m.result == nil {
+		g.printf(") error {\n")
+		g.printf("\t_, err := c.cli.Call(ctx, %q, %s)\n\treturn err\n}\n", rpcName, params)
+		return nil
+	}
+	rtype, err := g.typeString(m.result)
+	if err != nil {
+		return err
+	}
+	g.printf(") (%s, error) {\n", rtype)
+	g.printf("\tvar result %s\n", rtype)
+	g.printf("\terr := c.cli.CallResult(ctx, %q, %s, &result)\n\treturn result, err\n}\n", rpcName, params)
+	return nil
+}
+
+// typeString renders the type expression t, qualifying the types declared in
+// the service package if necessary.
+func (g *generator) typeString(t ast.Expr) (string, error) {
+	q, err := g.qualify(t)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, g.pkg.fset, q); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// qualify returns a copy of the type expression t in which the types declared
+// in the service package are qualified by g.qualifier, if it is set.
+func (g *generator) qualify(t ast.Expr) (ast.Expr, error) {
+	if g.qualifier == "" {
+		return t, nil
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		if predeclared[t.Name] {
+			return t, nil
+		} else if !ast.IsExported(t.Name) {
+			return nil, fmt.Errorf("type %s is not exported", t.Name)
+		}
+		return &ast.SelectorExpr{X: ast.NewIdent(g.qualifier), Sel: ast.NewIdent(t.Name)}, nil
+	case *ast.SelectorExpr, *ast.InterfaceType:
+		return t, nil
+	case *ast.StarExpr:
+		x, err := g.qualify(t.X)
+		return &ast.StarExpr{X: x}, err
+	case *ast.Ellipsis:
+		x, err := g.qualify(t.Elt)
+		return &ast.Ellipsis{Elt: x}, err
+	case *ast.ArrayType:
+		x, err := g.qualify(t.Elt)
+		return &ast.ArrayType{Len: t.Len, Elt: x}, err
+	case *ast.MapType:
+		k, err := g.qualify(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := g.qualify(t.Value)
+		return &ast.MapType{Key: k, Value: v}, err
+	case *ast.StructType:
+		fields := &ast.FieldList{}
+		for _, f := range t.Fields.List {
+			ft, err := g.qualify(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields.List = append(fields.List, &ast.Field{Names: f.Names, Type: ft, Tag: f.Tag})
+		}
+		return &ast.StructType{Fields: fields}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", t)
+}
+
+var predeclared = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true, "int8": true,
+	"int16": true, "int32": true, "int64": true, "rune": true, "string": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"uintptr": true,
+}
+
+// methodsOf returns the exported methods of the named type that have a
+// signature accepted by handler.New, ordered by name. If pointer is true, the
+// methods of a pointer to the type are included.
+func (p *goPackage) methodsOf(typeName string, pointer bool) ([]*method, error) {
+	found := false
+	var out []*method
+	for _, f := range p.files {
+		imports := fileImports(f)
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == typeName {
+						found = true
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil || !d.Name.IsExported() {
+					continue
+				} else if name, ptr := receiverName(d.Recv); name != typeName || ptr && !pointer {
+					continue
+				}
+				if m := newMethod(d, imports); m != nil {
+					out = append(out, m)
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// isComposite reports whether values of the parameter type t may encode as a
+// JSON array or object. A type that could not be resolved is assumed to.
+func (p *goPackage) isComposite(t ast.Expr) bool {
+	if _, ok := t.(*ast.Ellipsis); ok {
+		return true // variadic parameters are sent as an array
+	}
+	typ := p.info.TypeOf(t)
+	if typ == nil {
+		return true
+	} else if b, ok := typ.(*types.Basic); ok && b.Kind() == types.Invalid {
+		return true
+	}
+	return isCompositeType(typ)
+}
+
+// isCompositeType reports whether values of type t may encode as a JSON array
+// or object. A type with its own JSON encoding is assumed to do so.
+func isCompositeType(t types.Type) bool {
+	for {
+		if obj, _, _ := types.LookupFieldOrMethod(t, true, nil, "MarshalJSON"); obj != nil {
+			if _, ok := obj.(*types.Func); ok {
+				return true
+			}
+		}
+		ptr, ok := t.Underlying().(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = ptr.Elem()
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Struct, *types.Map, *types.Array, *types.Interface:
+		return true
+	case *types.Slice:
+		b, ok := u.Elem().Underlying().(*types.Basic)
+		return !ok || b.Kind() != types.Byte // []byte encodes as a string
+	}
+	return false
+}
+
+// receiverName returns the base type name of a method receiver, and reports
+// whether the receiver is a pointer.
+func receiverName(recv *ast.FieldList) (string, bool) {
+	t := recv.List[0].Type
+	s, ptr := t.(*ast.StarExpr)
+	if ptr {
+		t = s.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name, ptr
+	}
+	return "", ptr
+}
+
+// fileImports returns a map from package names to import paths for the
+// imports of f. The name of a package imported without an explicit name is
+// assumed to be the last element of its import path.
+func fileImports(f *ast.File) map[string]string {
+	out := make(map[string]string)
+	for _, spec := range f.Imports {
+		ipath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(ipath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		out[name] = ipath
+	}
+	return out
+}
+
+// newMethod returns a method for d, or nil if d does not have a signature
+// accepted by handler.New. The signatures are:
+//
+//    func(context.Context[, X]) error
+//    func(context.Context[, X]) Y
+//    func(context.Context[, X]) (Y, error)
+//
+// where X may be variadic or *jrpc2.Request.
+func newMethod(d *ast.FuncDecl, imports map[string]string) *method {
+	var params []ast.Expr
+	for _, f := range d.Type.Params.List {
+		for n := 0; n == 0 || n < len(f.Names); n++ {
+			params = append(params, f.Type)
+		}
+	}
+	var results []ast.Expr
+	if d.Type.Results != nil {
+		for _, f := range d.Type.Results.List {
+			for n := 0; n == 0 || n < len(f.Names); n++ {
+				results = append(results, f.Type)
+			}
+		}
+	}
+	if len(params) == 0 || len(params) > 2 || !isImported(params[0], imports, "context", "Context") {
+		return nil
+	} else if len(results) == 0 || len(results) > 2 {
+		return nil
+	} else if len(results) == 2 && !isIdent(results[1], "error") {
+		return nil
+	}
+
+	m := &method{name: d.Name.Name, doc: d.Doc, imports: make(map[string]string)}
+	if len(params) == 2 {
+		m.param = params[1]
+		if s, ok := m.param.(*ast.StarExpr); ok && isImported(s.X, imports, "github.com/creachadair/jrpc2", "Request") {
+			m.request = true
+		}
+	}
+	if len(results) == 2 || !isIdent(results[0], "error") {
+		m.result = results[0]
+	}
+
+	// Record the imports needed by the parameter and result types.
+	for _, t := range []ast.Expr{m.param, m.result} {
+		if t == nil || m.request && t == m.param {
+			continue
+		}
+		ast.Inspect(t, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok && imports[id.Name] != "" {
+					m.imports[id.Name] = imports[id.Name]
+				}
+				return false
+			}
+			return true
+		})
+	}
+	return m
+}
+
+// isImported reports whether t denotes the named type from the package with
+// the given import path.
+func isImported(t ast.Expr, imports map[string]string, ipath, name string) bool {
+	sel, ok := t.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && imports[id.Name] == ipath
+}
+
+func isIdent(t ast.Expr, name string) bool {
+	id, ok := t.(*ast.Ident)
+	return ok && id.Name == name
+}
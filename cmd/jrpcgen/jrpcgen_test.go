@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden output files")
+
+// Verify that the clients generated for the test service match the golden
+// output files in testdata, and that they type-check.
+func TestGenerate(t *testing.T) {
+	pkg, err := loadPackage(filepath.Join("testdata", "svc"))
+	if err != nil {
+		t.Fatalf("Loading package: %v", err)
+	}
+	tests := []struct {
+		golden string
+		pkg    string // if set, the import path of the service package
+		setup  func(*generator)
+	}{
+		// Methods with pointer receivers are excluded by default.
+		{"svc_client.golden", "", func(*generator) {}},
+
+		// With -pointer they are included.
+		{"svc_pointer.golden", "", func(g *generator) { g.pointer = true }},
+
+		// With -package and -import, the types of the service package are
+		// qualified and the package is imported.
+		{"svc_package.golden", "example.com/svc", func(g *generator) {
+			g.service = "Store"
+			g.outPkg = "client"
+			g.qualifier = "svc"
+			g.imports["svc"] = "example.com/svc"
+		}},
+	}
+	for _, test := range tests {
+		g := &generator{
+			pkg:     pkg,
+			client:  "SvcClient",
+			naming:  func(name string) string { return name },
+			outPkg:  pkg.name,
+			imports: make(map[string]string),
+		}
+		test.setup(g)
+		got, err := g.generate("Svc")
+		if err != nil {
+			t.Errorf("%s: generate failed: %v", test.golden, err)
+			continue
+		}
+		if diff := cmp.Diff([]string{
+			"Lookup: parameter type string does not encode as an array or object",
+			"Wait: parameter type time.Duration does not encode as an array or object",
+		}, g.skipped); diff != "" {
+			t.Errorf("%s: wrong skipped methods (-want, +got)\n%s", test.golden, diff)
+		}
+		if err := checkSource(pkg, test.pkg, got); err != nil {
+			t.Errorf("%s: generated code does not type-check: %v", test.golden, err)
+		}
+
+		path := filepath.Join("testdata", test.golden)
+		if *updateGolden {
+			if err := ioutil.WriteFile(path, got, 0644); err != nil {
+				t.Fatalf("Updating golden file: %v", err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Reading golden file: %v", err)
+		}
+		if diff := cmp.Diff(string(want), string(got)); diff != "" {
+			t.Errorf("%s: wrong output (-want, +got)\n%s", test.golden, diff)
+		}
+	}
+
+	// The service type must exist and have matching methods.
+	for _, name := range []string{"Nonesuch", "Item"} {
+		g := &generator{pkg: pkg, naming: func(s string) string { return s }, imports: make(map[string]string)}
+		if src, err := g.generate(name); err == nil {
+			t.Errorf("generate(%q): got %s, want error", name, src)
+		}
+	}
+}
+
+// checkSource type-checks the generated source src. If ipath == "", src is
+// checked as part of pkg; otherwise it is checked as a separate package that
+// imports pkg via ipath.
+func checkSource(pkg *goPackage, ipath string, src []byte) error {
+	dir := filepath.Join("testdata", "svc")
+	var files []*ast.File
+	for _, f := range pkg.files {
+		files = append(files, f)
+	}
+	imp := importer.ForCompiler(pkg.fset, "source", nil).(types.ImporterFrom)
+	conf := types.Config{Importer: imp}
+	if ipath != "" {
+		svc, err := conf.Check(ipath, pkg.fset, files, nil)
+		if err != nil {
+			return err
+		}
+		conf.Importer = importerFunc(func(path, dir string, mode types.ImportMode) (*types.Package, error) {
+			if path == ipath {
+				return svc, nil
+			}
+			return imp.ImportFrom(path, dir, mode)
+		})
+		files, dir = nil, "testdata"
+	}
+	f, err := parser.ParseFile(pkg.fset, filepath.Join(dir, "svc_client.go"), src, 0)
+	if err != nil {
+		return err
+	}
+	_, err = conf.Check(f.Name.Name, pkg.fset, append(files, f), nil)
+	return err
+}
+
+type importerFunc func(path, dir string, mode types.ImportMode) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path, "", 0) }
+
+func (f importerFunc) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	return f(path, dir, mode)
+}
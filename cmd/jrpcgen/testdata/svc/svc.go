@@ -0,0 +1,51 @@
+// Package svc is a service used to test jrpcgen.
+package svc
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// Svc is a test service.
+type Svc struct{}
+
+// An Item is a value stored by the service.
+type Item struct {
+	Name string    `json:"name"`
+	When time.Time `json:"when"`
+}
+
+// A Query selects an item.
+type Query struct {
+	Name string `json:"name"`
+}
+
+// Get returns the selected item.
+func (Svc) Get(ctx context.Context, q Query) (*Item, error) { return nil, nil }
+
+// Find returns the items matching the given values.
+func (Svc) Find(ctx context.Context, q url.Values) ([]Item, error) { return nil, nil }
+
+// Sum returns the sum of its arguments.
+func (Svc) Sum(ctx context.Context, vs ...int) int { return 0 }
+
+// Raw accepts any parameters.
+func (Svc) Raw(ctx context.Context, req *jrpc2.Request) ([]Item, error) { return nil, nil }
+
+func (Svc) Ping(ctx context.Context) error { return nil }
+
+// Lookup cannot be called, since its parameter is not an array or object.
+func (Svc) Lookup(ctx context.Context, name string) (*Item, error) { return nil, nil }
+
+// Wait cannot be called, since its parameter is not an array or object.
+func (Svc) Wait(ctx context.Context, d time.Duration) error { return nil }
+
+// Put stores the given items. It has a pointer receiver.
+func (*Svc) Put(ctx context.Context, items map[string]Item) error { return nil }
+
+func (Svc) NotAMethod(name string) error { return nil }
+
+func (Svc) unexported(ctx context.Context) error { return nil }
@@ -73,6 +73,8 @@ const (
 	SystemError      Code = -32098 // Errors from the operating environment
 	Cancelled        Code = -32097 // Request cancelled (context.Canceled)
 	DeadlineExceeded Code = -32096 // Request deadline exceeded (context.DeadlineExceeded)
+	Overloaded       Code = -32094 // Server is too busy to accept the request
+	Skipped          Code = -32093 // Request skipped after a failure earlier in its batch
 )
 
 var stdError = map[Code]string{
@@ -86,6 +88,8 @@ var stdError = map[Code]string{
 	SystemError:      "system error",
 	Cancelled:        "request cancelled",
 	DeadlineExceeded: "deadline exceeded",
+	Overloaded:       "server overloaded",
+	Skipped:          "request skipped",
 }
 
 // Register adds a new Code value with the specified message string.  This
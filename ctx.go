@@ -59,6 +59,41 @@ func PushCall(ctx context.Context, method string, params interface{}) (*Response
 	return s.Callback(ctx, method, params)
 }
 
+// ReportProgress posts a progress notification to the client for the inbound
+// request associated with ctx. The notification has the method name
+// "rpc.progress", and its parameters are an object whose "id" field is the ID
+// of the inbound request and whose "value" field is the encoding of value.
+// A client can receive these notifications for a call using WithProgress.
+//
+// As with PushNotify, this reports ErrPushUnsupported if server push is not
+// enabled. It reports an error if ctx has no inbound request, or if the
+// inbound request is a notification.
+func ReportProgress(ctx context.Context, value interface{}) error {
+	req := InboundRequest(ctx)
+	if req == nil || req.IsNotification() {
+		return errors.New("no request ID for progress")
+	}
+	bits, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return PushNotify(ctx, rpcProgress, progressParams{ID: req.id, Value: bits})
+}
+
+// WithProgress returns a child of ctx that, when used as the context for a
+// call by a *jrpc2.Client, causes progress notifications sent by the server
+// for that call (see ReportProgress) to be delivered to f along with the ID
+// of the request. Progress notifications for calls without a progress hook
+// are delivered to the OnNotify hook of the client, if one is set.
+//
+// The function f is called synchronously by the client while receiving from
+// the server, and must not block or call back into the client.
+func WithProgress(ctx context.Context, f func(id string, value json.RawMessage)) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+type progressKey struct{}
+
 // CancelRequest requests the cancellation of the pending or in-flight request
 // with the specified ID.  If no request exists with that ID, this is a no-op
 // without error.
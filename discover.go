@@ -0,0 +1,108 @@
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// OpenRPCVersion is the version of the OpenRPC specification implemented by
+// the documents reported by the rpc.discover built-in method.
+const OpenRPCVersion = "1.2.6"
+
+// An OpenRPC is a service description document reported by the rpc.discover
+// built-in method, as defined by the OpenRPC specification at
+// https://spec.open-rpc.org.
+type OpenRPC struct {
+	Version string       `json:"openrpc"`
+	Info    OpenRPCInfo  `json:"info"`
+	Methods []*MethodDoc `json:"methods"`
+}
+
+// OpenRPCInfo gives metadata about a service for an OpenRPC document.
+type OpenRPCInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// A MethodDoc describes a single method in an OpenRPC document.
+//
+// If the parameters of the method are an object with known fields, Params has
+// one entry for each field and ParamStructure is "by-name". Otherwise, Params
+// has at most one entry, named "params", that describes the complete
+// parameter value.
+type MethodDoc struct {
+	Name           string               `json:"name"`
+	Summary        string               `json:"summary,omitempty"`
+	Description    string               `json:"description,omitempty"`
+	Params         []*ContentDescriptor `json:"params"`
+	Result         *ContentDescriptor   `json:"result,omitempty"`
+	ParamStructure string               `json:"paramStructure,omitempty"`
+	Examples       []*Example           `json:"examples,omitempty"`
+}
+
+// A ContentDescriptor describes a parameter or result of a method, including
+// a JSON Schema for its value.
+type ContentDescriptor struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// An Example pairs example parameters for a method with the result they
+// produce.
+type Example struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Params      []*ExampleValue `json:"params"`
+	Result      *ExampleValue   `json:"result,omitempty"`
+}
+
+// An ExampleValue is a named example of a parameter or result value.
+type ExampleValue struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// A MethodDescriber is an optional interface that an Assigner may implement to
+// describe the methods it assigns to the rpc.discover built-in method. The
+// DescribeMethod method returns a description of the named method, or nil if
+// none is available. The assigners defined by the handler package implement
+// this interface for handlers constructed by handler.New.
+type MethodDescriber interface {
+	DescribeMethod(name string) *MethodDoc
+}
+
+// Handle the special rpc.discover method, that reports an OpenRPC document
+// describing the methods exported by the server.
+func (s *Server) handleRPCDiscover(context.Context, *Request) (interface{}, error) {
+	doc := &OpenRPC{Version: OpenRPCVersion, Info: *s.discover, Methods: []*MethodDoc{}}
+	desc, _ := s.mux.(MethodDescriber)
+	for _, name := range s.mux.Names() {
+		var m *MethodDoc
+		if desc != nil {
+			m = desc.DescribeMethod(name)
+		}
+		if m == nil {
+			m = new(MethodDoc)
+		}
+		m.Name = name
+		if m.Params == nil {
+			m.Params = []*ContentDescriptor{}
+		}
+		if s.annotate != nil {
+			s.annotate(m)
+		}
+		doc.Methods = append(doc.Methods, m)
+	}
+	return doc, nil
+}
+
+// RPCDiscover calls the built-in rpc.discover method exported by servers that
+// enable it (see the Discover server option). It is a convenience wrapper for
+// an invocation of cli.CallResult.
+func RPCDiscover(ctx context.Context, cli *Client) (result *OpenRPC, err error) {
+	err = cli.CallResult(ctx, rpcDiscover, nil, &result)
+	return
+}
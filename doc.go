@@ -32,6 +32,14 @@ reflection to lift its argument into the jrpc2.Handler interface:
 
    h := handler.New(Add)  // h is a jrpc2.Handler that invokes Add
 
+The function passed to handler.New may have at most one parameter besides the
+context. A function with several parameters can be adapted by handler.NewPos,
+given the names of its parameters:
+
+   func Scale(ctx context.Context, values []int, factor int) []int { ... }
+
+   h := handler.NewPos(Scale, "values", "factor=2")  // factor is optional
+
 We will advertise this function under the name "Add".  For static assignments
 we can use a handler.Map, which finds methods by looking them up in a Go map:
 
@@ -53,7 +61,8 @@ communicates on os.Stdin and os.Stdout:
 
 Once started, the running server will handle incoming requests until the
 channel closes, or until it is stopped explicitly by calling srv.Stop(). To
-wait for the server to finish, call:
+stop the server gracefully, allowing requests already in flight to complete,
+call srv.Shutdown(ctx) instead. To wait for the server to finish, call:
 
    err := srv.Wait()
 
@@ -108,8 +117,61 @@ To decode the result from a successful response use its UnmarshalResult method:
       log.Fatalln("UnmarshalResult:", err)
    }
 
+To issue a call without waiting for its response, use the Start method. This
+permits many calls to be pipelined over one connection from one goroutine:
+
+   p, err := cli.Start(ctx, "Add", []int{1, 3, 5, 7})
+   ...
+   select {
+   case <-p.Done():
+      rsp, err := p.Wait()  // does not block
+      ...
+   case <-timeout:
+      p.Cancel()
+   }
+
 To shut down a client and discard all its pending work, call cli.Close().
 
+The Interceptors field of the ClientOptions wraps every call, batch, and
+notification sent by the client, including calls made by Start, with access to
+the context, the methods and parameters, and the resulting responses.
+Interceptors are useful for attaching context metadata, logging, metrics, and
+tracing.
+
+By default a failed call is reported to the caller. The Retry field of the
+ClientOptions sets a policy for issuing calls again, with backoff, when they
+fail with designated error codes or transport errors.
+
+A Client does not reconnect if its channel fails. To maintain a connection
+across failures, use a RedialClient, which calls a function you provide to
+dial the server again, with backoff, when the connection is lost:
+
+   cli := jrpc2.NewRedialClient(func(ctx context.Context) (channel.Channel, error) {
+      conn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+      if err != nil {
+         return nil, err
+      }
+      return channel.Line(conn, conn), nil
+   }, nil)
+
+The RedialOptions control whether calls interrupted by a lost connection fail
+or are sent again, and permit the caller to observe changes in the state of
+the connection.
+
+To spread calls across several servers, such as the replicas of a service,
+use a Pool. A pool keeps a connection to each address, chooses a healthy
+endpoint for each call according to its Balance strategy, and ejects
+endpoints that fail until a background health probe succeeds again:
+
+   pool := jrpc2.NewPool(addrs, func(ctx context.Context, addr string) (channel.Channel, error) {
+      conn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+      if err != nil {
+         return nil, err
+      }
+      return channel.Line(conn, conn), nil
+   }, &jrpc2.PoolOptions{Balance: jrpc2.LeastPending})
+   defer pool.Close()
+
 
 Notifications
 
@@ -165,6 +227,9 @@ to produce a handler.Map for those methods:
 This assigner maps the name "Add" to the Add method, and the name "Mul" to the
 Mul method, of the math value.
 
+To export the methods under other names, such as "add" and "mul", or to export
+only some of them, use the handler.Service function with ServiceOptions.
+
 This may be further combined with the handler.ServiceMap type to allow
 different services to work together:
 
@@ -184,6 +249,21 @@ and "Status.Get" to the status value's method. A ServiceMap splits the method
 name on the first period ("."), and you may nest ServiceMaps more deeply if you
 require a more complex hierarchy.
 
+On the client side, the Bind method of a *Client fills in the function-valued
+fields of a struct with functions that call the corresponding methods:
+
+   var math struct {
+      Add func(context.Context, ...int) (int, error)
+      Mul func(context.Context, []int) (int, error)
+   }
+   if err := cli.Bind("Math", &math); err != nil {
+      log.Fatal(err)
+   }
+   sum, err := math.Add(ctx, 1, 2, 3)  // calls "Math.Add"
+
+The jrpcgen command generates similar typed client wrappers from the source of
+a service type.
+
 
 Concurrency
 
@@ -193,12 +273,34 @@ arrive as part of the same batch. In addition, two calls are concurrent if the
 time intervals between the arrival of the request objects and delivery of the
 response objects overlap.
 
+The MethodConcurrency option further bounds the number of concurrent requests
+for particular methods or groups of methods, so that a slow method cannot
+consume all the available capacity of the server.
+
+The OrderingKey option assigns requests to ordering keys, such as the name of
+a document the request modifies. Requests with the same key are executed one
+at a time in order of arrival, while requests with different keys may still
+run concurrently.
+
 The server may issue concurrent requests to their handlers in any order.
 Otherwise, requests are processed in order of arrival. Notifications, in
 particular, can only be concurrent with other notifications in the same batch.
 This ensures a client that sends a notification can be sure its notification
 was fully processed before any subsequent calls are issued.
 
+When the OrderedBatches option is set, the requests in each batch are instead
+executed one at a time, in the order they appear in the batch. A client may
+request this for a single batch by beginning it with an rpc.ordered
+notification (see below). With StopBatchOnError (or the stopOnError parameter
+of the marker), execution of an ordered batch stops at the first failure, and
+the remaining requests report errors with code.Skipped:
+
+   rsps, err := cli.Batch(ctx, []jrpc2.Spec{
+     {Method: "rpc.ordered", Params: map[string]bool{"stopOnError": true}, Notify: true},
+     {Method: "Doc.Create", Params: doc},
+     {Method: "Doc.Update", Params: edit},
+   })
+
 
 Non-Standard Extension Methods
 
@@ -211,9 +313,26 @@ methods:
   rpc.cancel([]int)  [notification]
   Request cancellation of the specified in-flight request IDs.
 
+  rpc.unsubscribe([]string) ⇒ bool
+  End the specified subscriptions (see Server Push), reporting whether any of
+  them were active.
+
+  rpc.ordered({"stopOnError": bool})  [notification]
+  When sent as the first element of a batch, execute the batch in order (see
+  Concurrency).
+
 The rpc.cancel method works only as a notification, and will report an error if
 called as an ordinary method.
 
+If the Discover server option is set, the server also exports:
+
+  rpc.discover(null) ⇒ jrpc2.OpenRPC
+  Returns an OpenRPC document describing the methods of the server.
+
+The method descriptions are derived from the types of the functions adapted by
+handler.New and handler.NewService, and may be annotated with summaries and
+examples by the AnnotateMethod server option.
+
 These extension methods are enabled by default, but may be disabled by setting
 the DisableBuiltin server option to true when constructing the server.
 
@@ -236,7 +355,36 @@ to the client:
 A method handler may use jrpc2.PushNotify and jrpc2.PushCall functions to
 access these methods.  On the client side, the OnNotify and OnCallback options
 in jrpc2.ClientOptions provide hooks to which any server requests are
-delivered, if they are set.
+delivered, if they are set. Alternatively, the Callbacks option routes server
+requests to handlers by method name, using an Assigner such as handler.Map.
+By default, the client handles server requests one at a time; set the
+CallbackConcurrency option to handle them concurrently, for example if a
+handler needs to call back into the server.
+
+A callback waits for the client to reply until its context ends. To bound the
+wait, pass a context with a deadline; if the CancelCallbacks server option is
+set, the client is sent an rpc.cancel notification for an abandoned callback.
+
+A long-running method handler may use the jrpc2.ReportProgress function to send
+progress notifications associated with its request to the client. The client
+can receive the progress for a particular call by passing a context prepared
+with jrpc2.WithProgress:
+
+  ctx := jrpc2.WithProgress(ctx, func(id string, value json.RawMessage) {
+    log.Printf("Progress for request %s: %s", id, value)
+  })
+  rsp, err := cli.Call(ctx, "methodName", params)
+
+A handler may also create a subscription, a stream of events pushed to the
+client until either side ends it, by calling jrpc2.NewSubscription and
+returning the ID of the subscription as its result. On the client side, the
+Subscribe method calls such a method and delivers the events on a channel:
+
+  sub, err := cli.Subscribe(ctx, "methodName", params)
+  ...
+  for evt := range sub.Events() {
+    // handle the event
+  }
 */
 package jrpc2
 
@@ -60,6 +60,14 @@ var ErrNoData = errors.New("no data to unmarshal")
 // an explicit call to its Stop method or orderly termination of its channel.
 var errServerStopped = errors.New("the server has been stopped")
 
+// errShuttingDown is reported for requests received by a server after its
+// Shutdown method has been called.
+var errShuttingDown = Errorf(code.SystemError, "server is shutting down")
+
+// errSkipped is reported for requests in an ordered batch that were not
+// executed because an earlier request in the batch failed.
+var errSkipped = Errorf(code.Skipped, "request skipped after an earlier request failed")
+
 // errClientStopped is the error reported when a client is shut down by an
 // explicit call to its Close method.
 var errClientStopped = errors.New("the client has been stopped")
@@ -68,6 +76,10 @@ var errClientStopped = errors.New("the client has been stopped")
 // the client connection is closed.
 var ErrConnClosed = errors.New("client connection is closed")
 
+// ErrConnLost is reported by a RedialClient for a request that failed because
+// the connection to the server was lost.
+var ErrConnLost = errors.New("connection to server lost")
+
 // Errorf returns an error value of concrete type *Error having the specified
 // code and formatted message string.
 // It is shorthand for DataErrorf(code, nil, msg, args...)
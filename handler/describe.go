@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// A FuncSchema describes the JSON encoding of the parameters and result of a
+// function adapted by New.
+type FuncSchema struct {
+	// The schema for the request parameters, or nil if the function does not
+	// accept parameters. A function that accepts the *jrpc2.Request accepts
+	// any parameters.
+	Params *Schema
+
+	// Whether the parameters are an array of positional values, as for a
+	// variadic function or one whose parameter type is Args. The parameters
+	// of a function adapted by NewPos are described as an object, but may
+	// also be given as an array in the order of its properties.
+	Positional bool
+
+	// The schema for the result, or nil if the function reports only an
+	// error, so that its result is always null.
+	Result *Schema
+}
+
+// Describe reports the schemas for the parameters and result of fn, which must
+// be a function having one of the signatures accepted by New, a Func, or a
+// Method. The schemas for a Func permit any value. Describe reports an error
+// if fn is not a valid function.
+func Describe(fn interface{}) (*FuncSchema, error) {
+	if fn == nil {
+		return nil, errors.New("nil method")
+	} else if m, ok := fn.(*Method); ok {
+		if fi := infoOf(m); fi != nil {
+			return fi.schema(), nil
+		}
+		return anyFunc(), nil
+	} else if _, ok := fn.(Func); ok {
+		return anyFunc(), nil
+	} else if _, ok := fn.(func(context.Context, *jrpc2.Request) (interface{}, error)); ok {
+		return anyFunc(), nil
+	}
+	typ, err := checkFunctionType(fn)
+	if err != nil {
+		return nil, err
+	}
+	return newFuncInfo(typ).schema(), nil
+}
+
+// anyFunc returns the schemas for a function that accepts any parameters and
+// returns any result.
+func anyFunc() *FuncSchema { return &FuncSchema{Params: new(Schema), Result: new(Schema)} }
+
+// Describe reports the schemas for the methods of m whose handlers were
+// constructed by New or NewPos, keyed by method name.
+func (m Map) Describe() map[string]*FuncSchema {
+	out := make(map[string]*FuncSchema)
+	for name, h := range m {
+		if fi := infoOf(h); fi != nil {
+			out[name] = fi.schema()
+		}
+	}
+	return out
+}
+
+// Describe reports the schemas for the methods of the services in m, keyed by
+// method names of the form Service.Method. Only the services whose assigners
+// have a Describe method like that of Map are included.
+func (m ServiceMap) Describe() map[string]*FuncSchema {
+	out := make(map[string]*FuncSchema)
+	for svc, assigner := range m {
+		d, ok := assigner.(interface{ Describe() map[string]*FuncSchema })
+		if !ok {
+			continue
+		}
+		for name, fs := range d.Describe() {
+			out[svc+"."+name] = fs
+		}
+	}
+	return out
+}
+
+// funcInfo records the signature of a function adapted by New.
+type funcInfo struct {
+	arg      reflect.Type // the parameter type, or nil if none
+	result   reflect.Type // the result type, or nil if none
+	variadic bool         // whether the function is variadic
+	params   []*posParam  // the parameters of a function adapted by NewPos
+}
+
+func newFuncInfo(typ reflect.Type) *funcInfo {
+	fi := &funcInfo{variadic: typ.IsVariadic()}
+	if typ.NumIn() == 2 {
+		fi.arg = typ.In(1)
+	}
+	if out := typ.Out(0); out != errType {
+		fi.result = out
+	}
+	return fi
+}
+
+var argsType = reflect.TypeOf(Args(nil))
+
+// schema returns the schemas for the signature described by fi.
+func (fi *funcInfo) schema() *FuncSchema {
+	fs := new(FuncSchema)
+	switch {
+	case fi.params != nil:
+		fs.Params = &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for _, p := range fi.params {
+			ps := schemaOf(p.typ)
+			ps.Default = p.value
+			fs.Params.Properties[p.name] = ps
+			fs.Params.order = append(fs.Params.order, p.name)
+			if !p.optional {
+				fs.Params.Required = append(fs.Params.Required, p.name)
+			}
+		}
+	case fi.arg == reqType:
+		fs.Params = new(Schema)
+	case fi.arg == argsType:
+		fs.Params = &Schema{Type: "array"}
+		fs.Positional = true
+	case fi.arg != nil:
+		fs.Params = schemaOf(fi.arg)
+		fs.Positional = fi.variadic
+	}
+	if fi.result != nil {
+		fs.Result = schemaOf(fi.result)
+	}
+	return fs
+}
+
+// infoOf returns the signature of h, if it is a Method constructed by New or
+// NewPos from a function that does not have the signature of a Func, or nil
+// otherwise.
+func infoOf(h jrpc2.Handler) *funcInfo {
+	if m, ok := h.(*Method); ok && m != nil {
+		return m.info
+	}
+	return nil
+}
+
+// methodDoc returns a description of a method with the given name whose
+// handler has the signature described by fi.
+func (fi *funcInfo) methodDoc(name string) *jrpc2.MethodDoc {
+	fs := fi.schema()
+	doc := &jrpc2.MethodDoc{Name: name, Params: []*jrpc2.ContentDescriptor{}}
+	if s := fs.Params; s != nil && s.Properties != nil {
+		doc.ParamStructure = "by-name"
+		if fi.params != nil {
+			doc.ParamStructure = "either"
+		}
+		required := make(map[string]bool)
+		for _, name := range s.Required {
+			required[name] = true
+		}
+		for _, name := range s.order {
+			doc.Params = append(doc.Params, &jrpc2.ContentDescriptor{
+				Name:     name,
+				Required: required[name],
+				Schema:   mustMarshal(s.Properties[name]),
+			})
+		}
+	} else if s != nil {
+		doc.Params = append(doc.Params, &jrpc2.ContentDescriptor{
+			Name:     "params",
+			Required: fi.arg != reqType,
+			Schema:   mustMarshal(s),
+		})
+	}
+	result := &Schema{Type: "null"}
+	if fs.Result != nil {
+		result = fs.Result
+	}
+	doc.Result = &jrpc2.ContentDescriptor{Name: "result", Schema: mustMarshal(result)}
+	return doc
+}
+
+func mustMarshal(s *Schema) json.RawMessage {
+	bits, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return bits
+}
+
+// DescribeMethod implements the jrpc2.MethodDescriber interface. It describes
+// methods whose handlers were constructed by New or NewPos, and returns nil
+// for others.
+func (m Map) DescribeMethod(name string) *jrpc2.MethodDoc {
+	if fi := infoOf(m[name]); fi != nil {
+		return fi.methodDoc(name)
+	}
+	return nil
+}
+
+// DescribeMethod implements the jrpc2.MethodDescriber interface. It delegates
+// to the assigner for the service named by method, if that assigner
+// implements jrpc2.MethodDescriber.
+func (m ServiceMap) DescribeMethod(method string) *jrpc2.MethodDoc {
+	parts := strings.SplitN(method, ".", 2)
+	if len(parts) == 1 {
+		return nil
+	} else if d, ok := m[parts[0]].(jrpc2.MethodDescriber); ok {
+		if doc := d.DescribeMethod(parts[1]); doc != nil {
+			doc.Name = method
+			return doc
+		}
+	}
+	return nil
+}
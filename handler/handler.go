@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path"
 	"reflect"
 	"strings"
 
@@ -23,6 +24,19 @@ func (m Func) Handle(ctx context.Context, req *jrpc2.Request) (interface{}, erro
 	return m(ctx, req)
 }
 
+// A Method is a jrpc2.Handler that calls a function adapted by New or NewPos.
+// It records the signature of the function, as reported by Describe and by
+// the Describe methods of Map and ServiceMap.
+type Method struct {
+	fn   Func
+	info *funcInfo // nil if the function has the signature of a Func
+}
+
+// Handle implements the jrpc2.Handler interface by calling the function.
+func (m *Method) Handle(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+	return m.fn(ctx, req)
+}
+
 // A Map is a trivial implementation of the jrpc2.Assigner interface that looks
 // up method names in a map of static jrpc2.Handler values.
 type Map map[string]jrpc2.Handler
@@ -89,7 +103,27 @@ func (m ServiceMap) Names() []string {
 //
 // Functions adapted by in this way can obtain the *jrpc2.Request value using
 // the jrpc2.InboundRequest helper on the context value supplied by the server.
-func New(fn interface{}) Func {
+//
+// To adapt a function with more than one parameter besides the context, use
+// NewPos.
+//
+// If the parameter type X is (or contains) a struct whose fields have
+// "validate" tags, the decoded parameters are checked against the rules in
+// those tags before fn is called. Parameters that violate the rules are
+// rejected with code.InvalidParams, and the error data list the offending
+// fields (see FieldError). For example:
+//
+//    type Params struct {
+//       Name  string `json:"name" validate:"required,max=64,pattern=^[a-z]+$"`
+//       Count int    `json:"count" validate:"min=1,max=100"`
+//       Mode  string `json:"mode,omitempty" validate:"enum=fast|slow"`
+//    }
+//
+// The options are required, min=N, max=N, enum=A|B|..., and pattern=RE. For
+// strings, slices, arrays, and maps, min and max constrain the length. The
+// pattern option must be last, since the expression may contain commas. New
+// panics if the rules are malformed.
+func New(fn interface{}) *Method {
 	m, err := newHandler(fn)
 	if err != nil {
 		panic(err)
@@ -101,21 +135,130 @@ func New(fn interface{}) Func {
 // Handler implementations as constructed by New. It will panic if obj has no
 // exported methods with a suitable signature.
 func NewService(obj interface{}) Map {
+	out, err := Service(obj, nil)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// ServiceOptions control the construction of a service by the Service
+// function. A nil *ServiceOptions provides default values.
+type ServiceOptions struct {
+	// If set, this function maps the name of each Go method to the name of
+	// the corresponding JSON-RPC method. The LowerCamelCase and SnakeCase
+	// functions implement common naming conventions. If nil, methods are
+	// exported under their Go names.
+	Naming func(string) string
+
+	// Rename maps the names of Go methods to the names under which they are
+	// exported, overriding Naming for those methods. It is an error if a key
+	// of Rename is not the name of a suitable method.
+	Rename map[string]string
+
+	// If non-empty, only the Go methods whose names match one of these
+	// patterns are exported. Patterns have the syntax of path.Match.
+	Include []string
+
+	// The Go methods whose names match one of these patterns are not
+	// exported. Patterns have the syntax of path.Match.
+	Exclude []string
+}
+
+func (o *ServiceOptions) naming() func(string) string {
+	if o == nil || o.Naming == nil {
+		return func(name string) string { return name }
+	}
+	return o.Naming
+}
+
+func (o *ServiceOptions) rename() map[string]string {
+	if o == nil {
+		return nil
+	}
+	return o.Rename
+}
+
+// exports reports whether the Go method with the given name is selected by
+// the Include and Exclude patterns of o.
+func (o *ServiceOptions) exports(name string) (bool, error) {
+	if o == nil {
+		return true, nil
+	}
+	ok := len(o.Include) == 0
+	for _, pat := range o.Include {
+		if match, err := path.Match(pat, name); err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %v", pat, err)
+		} else if match {
+			ok = true
+		}
+	}
+	for _, pat := range o.Exclude {
+		if match, err := path.Match(pat, name); err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %v", pat, err)
+		} else if match {
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// Service adapts the methods of a value to a map from method names to Handler
+// implementations as constructed by New, as NewService does, but with the
+// method names and selection controlled by opts. Unlike NewService, Service
+// reports an error rather than panicking if obj has no exported methods with
+// a suitable signature, if opts renames a method that is not exported, or if
+// two methods are exported under the same name.
+//
+// Example:
+//
+//    // Exports "get_user" and "delete_user" rather than GetUser and DeleteUser,
+//    // and "whoami" for the Self method. Debug methods are not exported.
+//    m, err := handler.Service(users, &handler.ServiceOptions{
+//       Naming:  handler.SnakeCase,
+//       Rename:  map[string]string{"Self": "whoami"},
+//       Exclude: []string{"Debug*"},
+//    })
+//
+func Service(obj interface{}, opts *ServiceOptions) (Map, error) {
 	out := make(Map)
 	val := reflect.ValueOf(obj)
 	typ := val.Type()
+	naming, rename := opts.naming(), opts.rename()
+	goName := make(map[string]string) // exported name → Go name
 
 	// This considers only exported methods, as desired.
 	for i, n := 0, val.NumMethod(); i < n; i++ {
+		name := typ.Method(i).Name
+		if ok, err := opts.exports(name); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
 		mi := val.Method(i)
-		if v, err := newHandler(mi.Interface()); err == nil {
-			out[typ.Method(i).Name] = v
+		v, err := newHandler(mi.Interface())
+		if err != nil {
+			continue
+		}
+		exported, ok := rename[name]
+		if !ok {
+			exported = naming(name)
+		}
+		if old, ok := goName[exported]; ok {
+			return nil, fmt.Errorf("methods %s and %s are both named %q", old, name, exported)
+		}
+		goName[exported] = name
+		out[exported] = v
+	}
+	for name, exported := range rename {
+		if goName[exported] != name {
+			return nil, fmt.Errorf("renamed method %s is not exported", name)
 		}
 	}
 	if len(out) == 0 {
-		panic("no matching exported methods")
+		return nil, errors.New("no matching exported methods")
 	}
-	return out
+	return out, nil
 }
 
 var (
@@ -124,7 +267,7 @@ var (
 	reqType = reflect.TypeOf((*jrpc2.Request)(nil))          // type *jrpc2.Request
 )
 
-func newHandler(fn interface{}) (Func, error) {
+func newHandler(fn interface{}) (*Method, error) {
 	if fn == nil {
 		return nil, errors.New("nil method")
 	}
@@ -132,7 +275,7 @@ func newHandler(fn interface{}) (Func, error) {
 	// Special case: If fn has the exact signature of the Handle method, don't do
 	// any (additional) reflection at all.
 	if f, ok := fn.(func(context.Context, *jrpc2.Request) (interface{}, error)); ok {
-		return Func(f), nil
+		return &Method{fn: f}, nil
 	}
 
 	// Check that fn is a function of one of the correct forms.
@@ -176,39 +319,73 @@ func newHandler(fn interface{}) (Func, error) {
 			argType = argType.Elem()
 		}
 
+		// If the argument has validation rules, check them after decoding.
+		check, err := newValidator(argType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validation rules: %v", err)
+		}
+
 		newinput = func(req *jrpc2.Request) ([]reflect.Value, error) {
 			in := reflect.New(argType).Interface()
 			if err := req.UnmarshalParams(in); err != nil {
 				return nil, jrpc2.Errorf(code.InvalidParams, "invalid parameters: %v", err)
 			}
 			arg := reflect.ValueOf(in)
+			if check != nil {
+				errs := check.validate(arg.Elem(), json.RawMessage(req.ParamString()))
+				if len(errs) != 0 {
+					return nil, jrpc2.DataErrorf(code.InvalidParams, errs,
+						"invalid parameters: %s", fieldErrorsString(errs))
+				}
+			}
 			return []reflect.Value{undo(arg)}, nil
 		}
 	}
 
 	// Construct a function to decode the result values.
-	var decodeOut func([]reflect.Value) (interface{}, error)
+	decodeOut := newDecodeOut(typ)
 
+	f := reflect.ValueOf(fn)
+	call := f.Call
+	if typ.IsVariadic() {
+		call = f.CallSlice
+	}
+
+	return &Method{
+		fn: func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+			rest, ierr := newinput(req)
+			if ierr != nil {
+				return nil, ierr
+			}
+			args := append([]reflect.Value{reflect.ValueOf(ctx)}, rest...)
+			return decodeOut(call(args))
+		},
+		info: newFuncInfo(typ),
+	}, nil
+}
+
+// newDecodeOut returns a function that decodes the result values of a call
+// to a function of type typ into a result and an error.
+func newDecodeOut(typ reflect.Type) func([]reflect.Value) (interface{}, error) {
 	switch typ.NumOut() {
 	case 1:
 		if typ.Out(0) == errType {
 			// A function that returns only error: Result is always nil.
-			decodeOut = func(vals []reflect.Value) (interface{}, error) {
+			return func(vals []reflect.Value) (interface{}, error) {
 				oerr := vals[0].Interface()
 				if oerr != nil {
 					return nil, oerr.(error)
 				}
 				return nil, nil
 			}
-		} else {
-			// A function that returns a single non-error: err is always nil.
-			decodeOut = func(vals []reflect.Value) (interface{}, error) {
-				return vals[0].Interface(), nil
-			}
+		}
+		// A function that returns a single non-error: err is always nil.
+		return func(vals []reflect.Value) (interface{}, error) {
+			return vals[0].Interface(), nil
 		}
 	default:
 		// A function that returns a value and an error.
-		decodeOut = func(vals []reflect.Value) (interface{}, error) {
+		return func(vals []reflect.Value) (interface{}, error) {
 			out, oerr := vals[0].Interface(), vals[1].Interface()
 			if oerr != nil {
 				return nil, oerr.(error)
@@ -216,21 +393,6 @@ func newHandler(fn interface{}) (Func, error) {
 			return out, nil
 		}
 	}
-
-	f := reflect.ValueOf(fn)
-	call := f.Call
-	if typ.IsVariadic() {
-		call = f.CallSlice
-	}
-
-	return Func(func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
-		rest, ierr := newinput(req)
-		if ierr != nil {
-			return nil, ierr
-		}
-		args := append([]reflect.Value{reflect.ValueOf(ctx)}, rest...)
-		return decodeOut(call(args))
-	}), nil
 }
 
 func checkFunctionType(fn interface{}) (reflect.Type, error) {
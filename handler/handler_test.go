@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/code"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -315,3 +317,419 @@ func ExampleObj_unmarshal() {
 	// Output:
 	// uid=501, name="P. T. Barnum"
 }
+
+// Verify that the schema for a type follows the rules of encoding/json.
+func TestSchema(t *testing.T) {
+	type inner struct {
+		Z bool `json:"z"`
+	}
+	type T struct {
+		inner
+		A int               `json:"a"`
+		B string            `json:"b,omitempty"`
+		C []float64         `json:"-"`
+		D map[string][]byte `json:"d,omitempty"`
+		E *T                `json:"e,omitempty"`
+		F interface{}
+		H *int      `json:"h"`
+		I [2]string `json:"i"`
+		g int
+	}
+	got, err := json.Marshal(schemaOf(reflect.TypeOf(T{})))
+	if err != nil {
+		t.Fatalf("Marshal schema: %v", err)
+	}
+	const want = `{"type":"object","properties":{` +
+		`"F":{},"a":{"type":"integer"},"b":{"type":"string"},` +
+		`"d":{"type":"object","additionalProperties":{"type":"string","format":"byte"}},` +
+		`"e":{},"h":{"type":["integer","null"]},` +
+		`"i":{"type":"array","items":{"type":"string"},"minItems":2,"maxItems":2},` +
+		`"z":{"type":"boolean"}},"required":["a","F","i","z"]}`
+	if string(got) != want {
+		t.Errorf("Schema:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+// Verify that Map and ServiceMap describe the methods constructed by New.
+func TestDescribeMethod(t *testing.T) {
+	type req struct {
+		Name  string `json:"name"`
+		Title string `json:"title,omitempty"`
+	}
+	m := ServiceMap{"Svc": Map{
+		"Greet": New(func(context.Context, req) (string, error) { return "", nil }),
+		"Sum":   New(func(context.Context, ...int) int { return 0 }),
+		"Nop":   New(func(context.Context) error { return nil }),
+		"Raw": Func(func(context.Context, *jrpc2.Request) (interface{}, error) {
+			panic("raw handler should not be called")
+		}),
+	}}
+	tests := []struct {
+		method, want string
+	}{
+		{"Svc.Greet", `{"name":"Svc.Greet","params":[` +
+			`{"name":"name","required":true,"schema":{"type":"string"}},` +
+			`{"name":"title","schema":{"type":"string"}}],` +
+			`"result":{"name":"result","schema":{"type":"string"}},"paramStructure":"by-name"}`},
+		{"Svc.Sum", `{"name":"Svc.Sum","params":[` +
+			`{"name":"params","required":true,"schema":{"type":"array","items":{"type":"integer"}}}],` +
+			`"result":{"name":"result","schema":{"type":"integer"}}}`},
+		{"Svc.Nop", `{"name":"Svc.Nop","params":[],"result":{"name":"result","schema":{"type":"null"}}}`},
+		{"Svc.Raw", `null`},
+		{"Svc.Nonesuch", `null`},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(m.DescribeMethod(test.method))
+		if err != nil {
+			t.Fatalf("Marshal %q: %v", test.method, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("DescribeMethod(%q):\ngot  %s\nwant %s", test.method, got, test.want)
+		}
+	}
+}
+
+// Verify that Describe reports schemas for the signatures accepted by New.
+func TestDescribe(t *testing.T) {
+	type arg struct {
+		X int     `json:"x"`
+		Y *string `json:"y"`
+	}
+	tests := []struct {
+		fn         interface{}
+		params     string
+		positional bool
+		result     string
+	}{
+		{func(context.Context) error { return nil }, "null", false, "null"},
+		{func(context.Context, arg) (*bool, error) { return nil, nil },
+			`{"type":"object","properties":{"x":{"type":"integer"},"y":{"type":["string","null"]}},"required":["x"]}`,
+			false, `{"type":["boolean","null"]}`},
+		{func(context.Context, ...string) int { return 0 },
+			`{"type":"array","items":{"type":"string"}}`, true, `{"type":"integer"}`},
+		{func(context.Context, Args) error { return nil }, `{"type":"array"}`, true, "null"},
+		{func(context.Context, *jrpc2.Request) (interface{}, error) { return nil, nil }, "{}", false, "{}"},
+		{New(func(context.Context, []int) (int, error) { return 0, nil }),
+			`{"type":"array","items":{"type":"integer"}}`, false, `{"type":"integer"}`},
+		{New(func(context.Context, *jrpc2.Request) (interface{}, error) { return nil, nil }), "{}", false, "{}"},
+		{Func(func(context.Context, *jrpc2.Request) (interface{}, error) { return nil, nil }), "{}", false, "{}"},
+	}
+	for i, test := range tests {
+		fs, err := Describe(test.fn)
+		if err != nil {
+			t.Errorf("Describe %d: unexpected error: %v", i, err)
+			continue
+		}
+		params, _ := json.Marshal(fs.Params)
+		result, _ := json.Marshal(fs.Result)
+		if string(params) != test.params || fs.Positional != test.positional || string(result) != test.result {
+			t.Errorf("Describe %d: got params %s, positional %v, result %s; want %s, %v, %s",
+				i, params, fs.Positional, result, test.params, test.positional, test.result)
+		}
+	}
+
+	for _, bad := range []interface{}{nil, "not a function", func(int) error { return nil }} {
+		if fs, err := Describe(bad); err == nil {
+			t.Errorf("Describe(%T): got %+v, want error", bad, fs)
+		}
+	}
+
+	m := ServiceMap{"Math": Map{
+		"Neg":  New(func(_ context.Context, x int) int { return -x }),
+		"Ping": Func(func(context.Context, *jrpc2.Request) (interface{}, error) { return nil, nil }),
+	}}
+	got := m.Describe()
+	if len(got) != 1 || got["Math.Neg"] == nil {
+		t.Errorf("ServiceMap.Describe: got %+v, want only Math.Neg", got)
+	}
+}
+
+// Verify that New checks the validation rules of parameter structs.
+func TestValidate(t *testing.T) {
+	type item struct {
+		Name string `json:"name" validate:"required,pattern=^[a-z]+$"`
+	}
+	type params struct {
+		Count int     `json:"count" validate:"min=1,max=10"`
+		Mode  string  `json:"mode,omitempty" validate:"enum=fast|slow"`
+		Label *string `json:"label" validate:"required,max=3"`
+		Items []item  `json:"items"`
+	}
+	h := New(func(_ context.Context, p *params) (int, error) { return p.Count, nil })
+
+	tests := []struct {
+		params string
+		want   []FieldError
+	}{
+		{`{"count":5,"mode":"fast","label":"abc","items":[{"name":"x"}]}`, nil},
+		{`{"count":0,"mode":"medium","items":[{"name":"ok"},{},{"name":"A1"}]}`, []FieldError{
+			{Path: "count", Message: "must be at least 1"},
+			{Path: "mode", Message: "must be one of fast, slow"},
+			{Path: "label", Message: "is required"},
+			{Path: "items[1].name", Message: "is required"},
+			{Path: "items[2].name", Message: `does not match pattern "^[a-z]+$"`},
+		}},
+		{`{"count":11,"label":"long"}`, []FieldError{
+			{Path: "count", Message: "must be at most 10"},
+			{Path: "label", Message: "length must be at most 3"},
+		}},
+		{`{"COUNT":3,"label":null}`, []FieldError{
+			{Path: "label", Message: "is required"},
+		}},
+	}
+	for _, test := range tests {
+		reqs, err := jrpc2.ParseRequests([]byte(`{"jsonrpc":"2.0","id":1,"method":"M","params":` + test.params + `}`))
+		if err != nil {
+			t.Fatalf("ParseRequests: %v", err)
+		}
+		_, err = h.Handle(context.Background(), reqs[0])
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("Params %s: unexpected error: %v", test.params, err)
+			}
+			continue
+		}
+		var got []FieldError
+		if e, ok := err.(*jrpc2.Error); !ok {
+			t.Errorf("Params %s: got error %v, want *jrpc2.Error", test.params, err)
+		} else if e.Code() != code.InvalidParams {
+			t.Errorf("Params %s: got code %v, want %v", test.params, e.Code(), code.InvalidParams)
+		} else if err := e.UnmarshalData(&got); err != nil {
+			t.Errorf("Params %s: invalid error data: %v", test.params, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Params %s: wrong field errors (-want, +got)\n%s", test.params, diff)
+		}
+	}
+
+	// Malformed rules are rejected when the handler is constructed.
+	for _, fn := range []interface{}{
+		func(context.Context, struct {
+			X int `validate:"pattern=x"`
+		}) error {
+			return nil
+		},
+		func(context.Context, []struct {
+			S string `validate:"min=z"`
+		}) error {
+			return nil
+		},
+		func(context.Context, struct {
+			S string `validate:"bogus"`
+		}) error {
+			return nil
+		},
+	} {
+		if _, err := newHandler(fn); err == nil {
+			t.Errorf("newHandler(%T): got nil, want error", fn)
+		}
+	}
+
+	// Validation rules are reflected in the schema.
+	got, err := json.Marshal(schemaOf(reflect.TypeOf(params{})))
+	if err != nil {
+		t.Fatalf("Marshal schema: %v", err)
+	}
+	const want = `{"type":"object","properties":{` +
+		`"count":{"type":"integer","minimum":1,"maximum":10},` +
+		`"items":{"type":"array","items":{"type":"object","properties":{` +
+		`"name":{"type":"string","pattern":"^[a-z]+$"}},"required":["name"]}},` +
+		`"label":{"type":["string","null"],"maxLength":3},` +
+		`"mode":{"type":"string","enum":["fast","slow"]}},` +
+		`"required":["count","label","items"]}`
+	if string(got) != want {
+		t.Errorf("Schema:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+type userService struct{}
+
+func (userService) GetUser(context.Context) error          { return nil }
+func (userService) DeleteUser(context.Context) error       { return nil }
+func (userService) ParseHTTPRequest(context.Context) error { return nil }
+func (userService) Self(context.Context) error             { return nil }
+func (userService) DebugDump(context.Context) error        { return nil }
+func (userService) Helper(int) string                      { return "" }
+
+// Verify that Service applies its naming and filtering options.
+func TestServiceOptions(t *testing.T) {
+	tests := []struct {
+		opts *ServiceOptions
+		want []string
+	}{
+		{nil, []string{"DebugDump", "DeleteUser", "GetUser", "ParseHTTPRequest", "Self"}},
+		{&ServiceOptions{Naming: LowerCamelCase},
+			[]string{"debugDump", "deleteUser", "getUser", "parseHTTPRequest", "self"}},
+		{&ServiceOptions{
+			Naming:  SnakeCase,
+			Rename:  map[string]string{"Self": "whoami"},
+			Exclude: []string{"Debug*"},
+		}, []string{"delete_user", "get_user", "parse_http_request", "whoami"}},
+		{&ServiceOptions{Include: []string{"*User"}, Exclude: []string{"Delete*"}},
+			[]string{"GetUser"}},
+	}
+	for _, test := range tests {
+		m, err := Service(userService{}, test.opts)
+		if err != nil {
+			t.Errorf("Service(%+v): unexpected error: %v", test.opts, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, m.Names()); diff != "" {
+			t.Errorf("Service(%+v) names: (-want, +got)\n%s", test.opts, diff)
+		}
+	}
+
+	for _, opts := range []*ServiceOptions{
+		{Include: []string{"Nothing"}},                                      // no methods
+		{Rename: map[string]string{"Helper": "help"}},                       // unsuitable method
+		{Rename: map[string]string{"Self": "x"}, Exclude: []string{"Self"}}, // excluded
+		{Rename: map[string]string{"Self": "GetUser"}},                      // name collision
+		{Naming: func(string) string { return "same" }},                     // name collision
+		{Exclude: []string{"["}},                                            // bad pattern
+	} {
+		if m, err := Service(userService{}, opts); err == nil {
+			t.Errorf("Service(%+v): got %v, want error", opts, m.Names())
+		}
+	}
+
+	for _, test := range []struct{ in, camel, snake string }{
+		{"Add", "add", "add"},
+		{"UserID", "userID", "user_id"},
+		{"HTTPServer", "httpServer", "http_server"},
+		{"Get2Items", "get2Items", "get2_items"},
+	} {
+		if got := LowerCamelCase(test.in); got != test.camel {
+			t.Errorf("LowerCamelCase(%q): got %q, want %q", test.in, got, test.camel)
+		}
+		if got := SnakeCase(test.in); got != test.snake {
+			t.Errorf("SnakeCase(%q): got %q, want %q", test.in, got, test.snake)
+		}
+	}
+}
+
+// Verify that NewPos binds parameters by position and by name.
+func TestNewPos(t *testing.T) {
+	h := NewPos(func(_ context.Context, query string, limit int, tags []string) string {
+		return fmt.Sprintf("%s/%d/%q", query, limit, tags)
+	}, "query", "limit=10", "tags?")
+
+	tests := []struct {
+		params, want, err string
+	}{
+		{`["foo"]`, `foo/10/[]`, ""},
+		{`["foo", 3]`, `foo/3/[]`, ""},
+		{`["foo", 3, ["a", "b"]]`, `foo/3/["a" "b"]`, ""},
+		{`{"query": "bar"}`, `bar/10/[]`, ""},
+		{`{"tags": ["c"], "query": "bar", "limit": 0}`, `bar/0/["c"]`, ""},
+
+		{`[]`, "", "got 0 parameters, want 1 to 3"},
+		{`["foo", 1, [], 2]`, "", "got 4 parameters, want 1 to 3"},
+		{`["foo", "bar"]`, "", `decoding "limit"`},
+		{`{"limit": 5}`, "", `missing required parameter "query"`},
+		{`{"query": "x", "other": 1}`, "", `unknown parameter "other"`},
+		{`null`, "", `missing required parameter "query"`},
+	}
+	for _, test := range tests {
+		req := mustParseRequest(t, test.params)
+		got, err := h.Handle(context.Background(), req)
+		if test.err != "" {
+			if code.FromError(err) != code.InvalidParams || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Params %s: got (%v, %v), want error containing %q", test.params, got, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("Params %s: unexpected error: %v", test.params, err)
+		} else if got != test.want {
+			t.Errorf("Params %s: got %q, want %q", test.params, got, test.want)
+		}
+	}
+
+	// All-optional parameters permit omitting the parameters entirely.
+	opt := NewPos(func(_ context.Context, n int) int { return n }, "n=7")
+	if got, err := opt.Handle(context.Background(), mustParseRequest(t, "")); err != nil || got != 7 {
+		t.Errorf("No params: got (%v, %v), want 7", got, err)
+	}
+
+	// A parameter that is present replaces its default, rather than being
+	// merged into it.
+	type opts struct {
+		N    int               `json:"n" validate:"min=1"`
+		Tags map[string]string `json:"tags,omitempty"`
+	}
+	merge := NewPos(func(_ context.Context, o opts) string {
+		return fmt.Sprintf("%d/%v", o.N, o.Tags)
+	}, `o={"n":3,"tags":{"a":"1"}}`)
+	for _, test := range []struct{ params, want string }{
+		{`[]`, `3/map[a:1]`},
+		{`[{"n":2}]`, `2/map[]`},
+		{`{"o":{"n":4,"tags":{"b":"2"}}}`, `4/map[b:2]`},
+	} {
+		got, err := merge.Handle(context.Background(), mustParseRequest(t, test.params))
+		if err != nil || got != test.want {
+			t.Errorf("Params %s: got (%v, %v), want %q", test.params, got, err, test.want)
+		}
+	}
+
+	// Parameters are checked against their validation rules, and the field
+	// paths begin with the parameter name.
+	for _, params := range []string{`[{"n":0}]`, `{"o":{"n":0}}`} {
+		_, err := merge.Handle(context.Background(), mustParseRequest(t, params))
+		var fe []FieldError
+		want := []FieldError{{Path: "o.n", Message: "must be at least 1"}}
+		if e, ok := err.(*jrpc2.Error); !ok || e.Code() != code.InvalidParams {
+			t.Errorf("Params %s: got error %v, want %v", params, err, code.InvalidParams)
+		} else if err := e.UnmarshalData(&fe); err != nil {
+			t.Errorf("Params %s: invalid error data: %v", params, err)
+		} else if diff := cmp.Diff(want, fe); diff != "" {
+			t.Errorf("Params %s: wrong field errors (-want, +got)\n%s", params, diff)
+		}
+	}
+
+	for _, bad := range []struct {
+		fn    interface{}
+		names []string
+	}{
+		{func(context.Context) error { return nil }, nil},
+		{func(context.Context, int, int) error { return nil }, []string{"a"}},
+		{func(context.Context, int, int) error { return nil }, []string{"a", "a"}},
+		{func(context.Context, int, int) error { return nil }, []string{"a?", "b"}},
+		{func(context.Context, int) error { return nil }, []string{"a=x"}},
+		{func(context.Context, ...int) error { return nil }, []string{"a"}},
+		{func(int, int) error { return nil }, []string{"a", "b"}},
+		{func(context.Context, struct {
+			X int `validate:"pattern=x"`
+		}) error {
+			return nil
+		}, []string{"a"}},
+	} {
+		if _, err := newPosHandler(bad.fn, bad.names); err == nil {
+			t.Errorf("newPosHandler(%T, %q): got nil, want error", bad.fn, bad.names)
+		}
+	}
+
+	// The parameters are described as an object.
+	m := Map{"Search": h}
+	got, err := json.Marshal(m.DescribeMethod("Search"))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const want = `{"name":"Search","params":[` +
+		`{"name":"query","required":true,"schema":{"type":"string"}},` +
+		`{"name":"limit","schema":{"type":"integer","default":10}},` +
+		`{"name":"tags","schema":{"type":"array","items":{"type":"string"}}}],` +
+		`"result":{"name":"result","schema":{"type":"string"}},"paramStructure":"either"}`
+	if string(got) != want {
+		t.Errorf("DescribeMethod:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func mustParseRequest(t *testing.T, params string) *jrpc2.Request {
+	t.Helper()
+	msg := `{"jsonrpc":"2.0","id":1,"method":"M"`
+	if params != "" {
+		msg += `,"params":` + params
+	}
+	reqs, err := jrpc2.ParseRequests([]byte(msg + "}"))
+	if err != nil {
+		t.Fatalf("ParseRequests: %v", err)
+	}
+	return reqs[0]
+}
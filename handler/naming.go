@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LowerCamelCase converts a Go method name to lower camel case, for use as the
+// Naming option of a ServiceOptions. For example, "GetUser" becomes "getUser",
+// and "HTTPStatus" becomes "httpStatus".
+func LowerCamelCase(name string) string {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return name
+	}
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+// SnakeCase converts a Go method name to snake case, for use as the Naming
+// option of a ServiceOptions. For example, "GetUser" becomes "get_user", and
+// "UserID" becomes "user_id".
+func SnakeCase(name string) string {
+	words := splitWords(name)
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+	}
+	return strings.Join(words, "_")
+}
+
+// splitWords splits a mixed-case Go identifier into words. A word begins at
+// an upper-case letter that follows a lower-case letter or digit, or that
+// begins an upper-case run ending in a lower-case letter, so that initialisms
+// are kept together: "ParseHTTPRequest2" is "Parse", "HTTP", "Request2".
+func splitWords(name string) []string {
+	rs := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(rs); i++ {
+		if !unicode.IsUpper(rs[i]) {
+			continue
+		}
+		prev := rs[i-1]
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+			(unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			words = append(words, string(rs[start:i]))
+			start = i
+		}
+	}
+	if start < len(rs) {
+		words = append(words, string(rs[start:]))
+	}
+	return words
+}
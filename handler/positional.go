@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/code"
+)
+
+// NewPos adapts a function with multiple parameters to a jrpc2.Handler. The
+// concrete value of fn must be a function with one of the following type
+// signatures:
+//
+//    func(context.Context, X1, ..., Xn) error
+//    func(context.Context, X1, ..., Xn) Y
+//    func(context.Context, X1, ..., Xn) (Y, error)
+//
+// for JSON-marshalable types X1...Xn and Y, where n ≥ 1. The function may not
+// be variadic. The names must give a name for each of the parameters X1...Xn.
+//
+// The request parameters may be either a JSON array, whose elements are
+// bound to X1...Xn in order, or a JSON object, whose fields are bound to the
+// parameters with the corresponding names. A name may have one of the forms:
+//
+//    name          the parameter is required
+//    name?         the parameter is optional; if omitted, it is the zero value
+//    name=value    the parameter is optional; if omitted, it is decoded from
+//                  the JSON value, e.g., "limit=10" or `tags=["a","b"]`
+//
+// Optional parameters must follow all the required parameters, so that an
+// array may omit any number of trailing optional parameters. A parameter that
+// is present replaces its default entirely; it is not merged with it.
+//
+// Parameters of struct type are validated as for New (see FieldError). The
+// path of each field error begins with the name of the parameter. Default
+// values are not validated. For example:
+//
+//    func Search(ctx context.Context, query string, limit int, exact bool) ([]string, error)
+//
+//    h := handler.NewPos(Search, "query", "limit=10", "exact?")
+//
+// accepts parameters ["foo"], ["foo", 5], {"query": "foo", "exact": true},
+// and so on.
+//
+// NewPos panics if the type of fn does not have one of these forms, or if the
+// names are invalid.
+func NewPos(fn interface{}, names ...string) *Method {
+	m, err := newPosHandler(fn, names)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// A posParam is a parameter of a function adapted by NewPos.
+type posParam struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+	value    json.RawMessage // the default value, if any
+	check    *validator      // validation rules, or nil
+}
+
+// zero returns a new addressable value for p, initialized to its default.
+func (p *posParam) zero() reflect.Value {
+	v := reflect.New(p.typ).Elem()
+	if p.value != nil {
+		json.Unmarshal(p.value, v.Addr().Interface()) // checked by newPosHandler
+	}
+	return v
+}
+
+// decode decodes raw as the value of p, and checks the validation rules of p,
+// adding any failures to errs.
+func (p *posParam) decode(raw json.RawMessage, errs *[]FieldError) (reflect.Value, error) {
+	v := reflect.New(p.typ)
+	if err := json.Unmarshal(raw, v.Interface()); err != nil {
+		return v, fmt.Errorf("decoding %q: %v", p.name, err)
+	}
+	if p.check != nil {
+		p.check.check(v.Elem(), raw, p.name, errs)
+	}
+	return v.Elem(), nil
+}
+
+// parsePosParam parses a parameter name for NewPos, for a parameter of type t.
+func parsePosParam(name string, t reflect.Type) (*posParam, error) {
+	p := &posParam{name: name, typ: t}
+	if i := strings.Index(name, "="); i >= 0 {
+		p.name, p.optional, p.value = name[:i], true, json.RawMessage(name[i+1:])
+		if err := json.Unmarshal(p.value, reflect.New(t).Interface()); err != nil {
+			return nil, fmt.Errorf("invalid default for %q: %v", p.name, err)
+		}
+	} else if strings.HasSuffix(name, "?") {
+		p.name, p.optional = strings.TrimSuffix(name, "?"), true
+	}
+	if p.name == "" {
+		return nil, errors.New("empty parameter name")
+	}
+	check, err := newValidator(t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validation rules for %q: %v", p.name, err)
+	}
+	p.check = check
+	return p, nil
+}
+
+func newPosHandler(fn interface{}, names []string) (*Method, error) {
+	if fn == nil {
+		return nil, errors.New("nil method")
+	}
+	typ := reflect.TypeOf(fn)
+	if typ.Kind() != reflect.Func {
+		return nil, errors.New("not a function")
+	} else if typ.NumIn() < 2 {
+		return nil, errors.New("wrong number of parameters")
+	} else if typ.IsVariadic() {
+		return nil, errors.New("variadic functions are not supported")
+	} else if no := typ.NumOut(); no < 1 || no > 2 {
+		return nil, errors.New("wrong number of results")
+	} else if typ.In(0) != ctxType {
+		return nil, errors.New("first parameter is not context.Context")
+	} else if no == 2 && typ.Out(1) != errType {
+		return nil, errors.New("result is not of type error")
+	} else if len(names) != typ.NumIn()-1 {
+		return nil, fmt.Errorf("got %d names for %d parameters", len(names), typ.NumIn()-1)
+	}
+
+	params := make([]*posParam, len(names))
+	index := make(map[string]int)
+	nreq := 0
+	for i, name := range names {
+		p, err := parsePosParam(name, typ.In(i+1))
+		if err != nil {
+			return nil, err
+		} else if _, ok := index[p.name]; ok {
+			return nil, fmt.Errorf("duplicate parameter name %q", p.name)
+		} else if !p.optional {
+			if nreq != i {
+				return nil, fmt.Errorf("required parameter %q follows an optional parameter", p.name)
+			}
+			nreq++
+		}
+		params[i] = p
+		index[p.name] = i
+	}
+
+	decodeOut := newDecodeOut(typ)
+	call := reflect.ValueOf(fn).Call
+	info := &funcInfo{params: params}
+	if out := typ.Out(0); out != errType {
+		info.result = out
+	}
+
+	return &Method{
+		fn: func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+			args := make([]reflect.Value, len(params)+1)
+			args[0] = reflect.ValueOf(ctx)
+			for i, p := range params {
+				args[i+1] = p.zero()
+			}
+			errs, err := bindParams(req, params, index, nreq, args[1:])
+			if err != nil {
+				return nil, jrpc2.Errorf(code.InvalidParams, "invalid parameters: %v", err)
+			} else if len(errs) != 0 {
+				return nil, jrpc2.DataErrorf(code.InvalidParams, errs,
+					"invalid parameters: %s", fieldErrorsString(errs))
+			}
+			return decodeOut(call(args))
+		},
+		info: info,
+	}, nil
+}
+
+// bindParams decodes the parameters of req into args, which must already be
+// initialized to the default values of params. It returns the field errors
+// for any parameters that failed validation.
+func bindParams(req *jrpc2.Request, params []*posParam, index map[string]int, nreq int, args []reflect.Value) ([]FieldError, error) {
+	var errs []FieldError
+	raw := strings.TrimSpace(req.ParamString())
+	switch {
+	case raw == "" || raw == "null":
+		if nreq != 0 {
+			return nil, fmt.Errorf("missing required parameter %q", params[0].name)
+		}
+		return nil, nil
+
+	case raw[0] == '[':
+		var elts []json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &elts); err != nil {
+			return nil, err
+		} else if len(elts) < nreq || len(elts) > len(params) {
+			if nreq == len(params) {
+				return nil, fmt.Errorf("got %d parameters, want %d", len(elts), nreq)
+			}
+			return nil, fmt.Errorf("got %d parameters, want %d to %d", len(elts), nreq, len(params))
+		}
+		for i, elt := range elts {
+			v, err := params[i].decode(elt, &errs)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return errs, nil
+
+	case raw[0] == '{':
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			return nil, err
+		}
+		for _, p := range params[:nreq] {
+			if _, ok := obj[p.name]; !ok {
+				return nil, fmt.Errorf("missing required parameter %q", p.name)
+			}
+		}
+		for key := range obj {
+			if _, ok := index[key]; !ok {
+				return nil, fmt.Errorf("unknown parameter %q", key)
+			}
+		}
+		// Decode in parameter order, so that field errors are reported in a
+		// stable order.
+		for i, p := range params {
+			val, ok := obj[p.name]
+			if !ok {
+				continue
+			}
+			v, err := p.decode(val, &errs)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return errs, nil
+	}
+	return nil, errors.New("parameters must be an array or object")
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// A Schema is a JSON Schema describing the encoding of a Go value, as derived
+// from its type. Only the subset of JSON Schema needed to describe the
+// encoding of Go types is represented.
+//
+// Values of pointer type are nullable, and a struct field of pointer type is
+// not required. Otherwise, a struct field is required unless its json tag
+// has the "omitempty" option. The validation rules in the "validate" tags of
+// struct fields (see New) are reflected in the schemas of the fields.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Nullable             bool               `json:"-"` // the value may be null
+	Description          string             `json:"description,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              json.RawMessage    `json:"default,omitempty"`
+
+	order []string // property names in declaration order
+}
+
+// MarshalJSON encodes s as JSON. The type of a nullable schema is encoded as
+// an array that includes "null".
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema // N.B. drop the MarshalJSON method
+	if !s.Nullable || s.Type == "" {
+		return json.Marshal((*schema)(s))
+	}
+	return json.Marshal(struct {
+		Type []string `json:"type"`
+		*schema
+	}{Type: []string{s.Type, "null"}, schema: (*schema)(s)})
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns a schema describing the JSON encoding of values of type t.
+// Types whose encoding cannot be determined from their structure, such as
+// interfaces and types with custom JSON encodings, are described by an empty
+// schema that permits any value.
+func schemaOf(t reflect.Type) *Schema {
+	return schemaFor(t, make(map[reflect.Type]bool))
+}
+
+// schemaFor returns a schema for t. The seen map records the struct types
+// currently being described, to prevent unbounded recursion.
+func schemaFor(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t.Kind() == reflect.Ptr {
+		s := schemaFor(t.Elem(), seen)
+		s.Nullable = true
+		return s
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return new(Schema) // custom encoding
+	} else if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"} // base64
+		}
+		s := &Schema{Type: "array", Items: schemaFor(t.Elem(), seen)}
+		if t.Kind() == reflect.Array {
+			n := t.Len()
+			s.MinItems, s.MaxItems = &n, &n
+		}
+		return s
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return new(Schema) // recursive type
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(s, t, seen)
+		return s
+	}
+	return new(Schema) // interfaces and other types
+}
+
+// addFields adds the fields of struct type t to the properties of s,
+// following the rules of the encoding/json package for field names and
+// embedded structs.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := parseTag(f.Tag.Get("json"))
+		if name == "-" && opts == "" {
+			continue // explicitly omitted
+		} else if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		} else if name == "" {
+			name = f.Name
+		}
+		if _, ok := s.Properties[name]; ok {
+			continue // shadowed by a shallower field
+		}
+		fs := schemaFor(f.Type, seen)
+		r, _ := parseRules(f.Tag.Get("validate"), f.Type) // checked by New
+		if r != nil {
+			r.apply(fs)
+		}
+		s.Properties[name] = fs
+		s.order = append(s.order, name)
+		if r != nil && r.required {
+			s.Required = append(s.Required, name)
+		} else if f.Type.Kind() != reflect.Ptr && !strings.Contains(","+opts+",", ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	// Promote the fields of embedded structs, unless shadowed.
+	for _, et := range embedded {
+		addFields(s, et, seen)
+	}
+}
+
+// parseTag splits a json struct tag into its name and options.
+func parseTag(tag string) (name, opts string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// apply adds the constraints of r to the schema s for a field.
+func (r *rules) apply(s *Schema) {
+	intOf := func(f *float64) *int {
+		if f == nil {
+			return nil
+		}
+		n := int(*f)
+		return &n
+	}
+	switch s.Type {
+	case "integer", "number":
+		s.Minimum, s.Maximum = r.min, r.max
+	case "string":
+		s.MinLength, s.MaxLength = intOf(r.min), intOf(r.max)
+	case "array":
+		if r.min != nil {
+			s.MinItems = intOf(r.min)
+		}
+		if r.max != nil {
+			s.MaxItems = intOf(r.max)
+		}
+	}
+	for _, e := range r.enum {
+		var v interface{} = e
+		if s.Type != "string" {
+			json.Unmarshal([]byte(e), &v)
+		}
+		s.Enum = append(s.Enum, v)
+	}
+	if r.pattern != nil {
+		s.Pattern = r.pattern.String()
+	}
+}
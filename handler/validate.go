@@ -0,0 +1,362 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A FieldError describes a request parameter that failed validation. When the
+// parameters of a function adapted by New or NewPos fail validation, the
+// handler reports an error with code.InvalidParams whose data are a JSON array
+// of FieldError values, one for each offending field.
+type FieldError struct {
+	// The path of the offending field, composed of JSON field names separated
+	// by periods, with array indices and map keys in brackets, for example
+	// "items[2].name". An empty path denotes the parameters as a whole.
+	Path string `json:"path"`
+
+	// A human-readable description of the problem.
+	Message string `json:"message"`
+}
+
+func (f FieldError) String() string {
+	if f.Path == "" {
+		return f.Message
+	}
+	return f.Path + " " + f.Message
+}
+
+// rules are the validation constraints for a struct field, parsed from the
+// "validate" tag of the field. The tag is a comma-separated list of options:
+//
+//    required      the field must be present and not null
+//    min=N         the minimum numeric value, or the minimum length of a
+//                  string, slice, array, or map
+//    max=N         the maximum numeric value or length, as for min
+//    enum=A|B|...  the value must be one of the listed values
+//    pattern=RE    a string value must match the regular expression RE
+//
+// Because the regular expression may contain commas, the pattern option must
+// be the last in the tag.
+type rules struct {
+	required bool
+	min, max *float64
+	enum     []string
+	pattern  *regexp.Regexp
+}
+
+// parseRules parses the validation rules from tag, and checks that they are
+// applicable to values of type t. It returns nil if tag is empty.
+func parseRules(tag string, t reflect.Type) (*rules, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	r := new(rules)
+	for tag != "" {
+		var opt string
+		if strings.HasPrefix(tag, "pattern=") {
+			opt, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			opt, tag = tag[:i], tag[i+1:]
+		} else {
+			opt, tag = tag, ""
+		}
+		key, arg := opt, ""
+		if i := strings.Index(opt, "="); i >= 0 {
+			key, arg = opt[:i], opt[i+1:]
+		}
+
+		switch key {
+		case "required":
+			r.required = true
+		case "min", "max":
+			if !isNumber(t) && !hasLength(t) {
+				return nil, fmt.Errorf("%s does not apply to type %v", key, t)
+			}
+			v, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value %q", key, arg)
+			} else if key == "min" {
+				r.min = &v
+			} else {
+				r.max = &v
+			}
+		case "enum":
+			if !isNumber(t) && t.Kind() != reflect.String && t.Kind() != reflect.Bool {
+				return nil, fmt.Errorf("enum does not apply to type %v", t)
+			}
+			r.enum = strings.Split(arg, "|")
+		case "pattern":
+			if t.Kind() != reflect.String {
+				return nil, fmt.Errorf("pattern does not apply to type %v", t)
+			}
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %v", err)
+			}
+			r.pattern = re
+		default:
+			return nil, fmt.Errorf("unknown validation option %q", key)
+		}
+	}
+	return r, nil
+}
+
+// check reports the ways in which v, which must not be a nil pointer, violates
+// the value constraints of r.
+func (r *rules) check(v reflect.Value) []string {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var msgs []string
+	if r.min != nil || r.max != nil {
+		what, n := "", 0.0
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		case reflect.String:
+			what, n = "length ", float64(utf8.RuneCountInString(v.String()))
+		default:
+			what, n = "length ", float64(v.Len())
+		}
+		if r.min != nil && n < *r.min {
+			msgs = append(msgs, fmt.Sprintf("%smust be at least %v", what, *r.min))
+		}
+		if r.max != nil && n > *r.max {
+			msgs = append(msgs, fmt.Sprintf("%smust be at most %v", what, *r.max))
+		}
+	}
+	if r.enum != nil {
+		s := fmt.Sprint(v.Interface())
+		ok := false
+		for _, e := range r.enum {
+			if e == s {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			msgs = append(msgs, "must be one of "+strings.Join(r.enum, ", "))
+		}
+	}
+	if r.pattern != nil && !r.pattern.MatchString(v.String()) {
+		msgs = append(msgs, fmt.Sprintf("does not match pattern %q", r.pattern))
+	}
+	return msgs
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func hasLength(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// A validator checks a value of some type against the validation rules of the
+// struct fields reachable from it.
+type validator struct {
+	fields []*fieldCheck // for struct types
+	elem   *validator    // for pointer, slice, array, and map types
+}
+
+// A fieldCheck is the validation for one field of a struct.
+type fieldCheck struct {
+	index int        // the index of the field in its struct
+	name  string     // the JSON name of the field, or "" if embedded
+	rules *rules     // constraints on the field value, or nil
+	sub   *validator // checks for the contents of the field value, or nil
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// newValidator constructs a validator for values of type t. It returns nil if
+// no validation rules apply to values of type t, and an error if any of the
+// rules are invalid.
+func newValidator(t reflect.Type) (*validator, error) {
+	return compileValidator(t, make(map[reflect.Type]*validator))
+}
+
+// compileValidator constructs a validator for t. The memo records the struct
+// types already visited, so that recursive types are handled.
+func compileValidator(t reflect.Type, memo map[reflect.Type]*validator) (*validator, error) {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil, nil // custom decoding
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		elem, err := compileValidator(t.Elem(), memo)
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		return &validator{elem: elem}, nil
+	case reflect.Struct:
+	default:
+		return nil, nil
+	}
+	if v, ok := memo[t]; ok {
+		return v, nil
+	}
+	v := new(validator)
+	memo[t] = v
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _ := parseTag(f.Tag.Get("json"))
+		if name == "-" {
+			continue
+		}
+		fc := &fieldCheck{index: i}
+		if f.Anonymous && name == "" && indirect(f.Type).Kind() == reflect.Struct {
+			// Embedded struct: Its fields are promoted.
+		} else if f.PkgPath != "" {
+			continue // unexported
+		} else if name == "" {
+			fc.name = f.Name
+		} else {
+			fc.name = name
+		}
+
+		r, err := parseRules(f.Tag.Get("validate"), f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		} else if r != nil && fc.name == "" {
+			return nil, fmt.Errorf("field %s: embedded fields cannot be validated", f.Name)
+		}
+		fc.rules = r
+		fc.sub, err = compileValidator(f.Type, memo)
+		if err != nil {
+			return nil, err
+		}
+		if fc.rules != nil || fc.sub != nil {
+			v.fields = append(v.fields, fc)
+		}
+	}
+	if len(v.fields) == 0 {
+		delete(memo, t)
+		return nil, nil
+	}
+	return v, nil
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// validate checks v, which was decoded from raw, and returns the resulting
+// field errors, if any.
+func (c *validator) validate(v reflect.Value, raw json.RawMessage) []FieldError {
+	var errs []FieldError
+	c.check(v, raw, "", &errs)
+	return errs
+}
+
+// check checks v, which was decoded from raw, adding any errors to errs. The
+// path is the location of v relative to the parameters.
+func (c *validator) check(v reflect.Value, raw json.RawMessage, path string, errs *[]FieldError) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			c.elem.check(v.Elem(), raw, path, errs)
+		}
+
+	case reflect.Slice, reflect.Array:
+		var elts []json.RawMessage
+		json.Unmarshal(raw, &elts)
+		for i := 0; i < v.Len() && i < len(elts); i++ {
+			c.elem.check(v.Index(i), elts[i], fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		json.Unmarshal(raw, &obj)
+		for _, key := range v.MapKeys() {
+			k := fmt.Sprint(key.Interface())
+			c.elem.check(v.MapIndex(key), obj[k], fmt.Sprintf("%s[%q]", path, k), errs)
+		}
+
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		json.Unmarshal(raw, &obj)
+		for _, fc := range c.fields {
+			fv := v.Field(fc.index)
+			if fc.name == "" {
+				// An embedded struct shares the object of its container. If
+				// the pointer is nil, check the rules against a zero value.
+				if fv.Kind() == reflect.Ptr && fv.IsNil() {
+					fv = reflect.New(fv.Type().Elem())
+				}
+				fc.sub.check(fv, raw, path, errs)
+				continue
+			}
+
+			fpath := fc.name
+			if path != "" {
+				fpath = path + "." + fc.name
+			}
+			fraw := lookupField(obj, fc.name)
+			if len(fraw) == 0 || string(fraw) == "null" {
+				if fc.rules != nil && fc.rules.required {
+					*errs = append(*errs, FieldError{Path: fpath, Message: "is required"})
+				}
+				continue
+			}
+			if fc.rules != nil {
+				for _, msg := range fc.rules.check(fv) {
+					*errs = append(*errs, FieldError{Path: fpath, Message: msg})
+				}
+			}
+			if fc.sub != nil {
+				fc.sub.check(fv, fraw, fpath, errs)
+			}
+		}
+	}
+}
+
+// lookupField returns the value of the named field of obj. As in the
+// encoding/json package, an exact match is preferred, but otherwise the name
+// is matched without regard to case.
+func lookupField(obj map[string]json.RawMessage, name string) json.RawMessage {
+	if v, ok := obj[name]; ok {
+		return v
+	}
+	for key, v := range obj {
+		if strings.EqualFold(key, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// fieldErrorsString renders errs as a human-readable string.
+func fieldErrorsString(errs []FieldError) string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.String()
+	}
+	return strings.Join(msgs, "; ")
+}
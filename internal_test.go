@@ -252,6 +252,21 @@ func TestDisableBuiltin(t *testing.T) {
 	}
 }
 
+// Verify that the timeout context of a request that fails before its handler
+// runs is released immediately.
+func TestTimeoutReleased(t *testing.T) {
+	s := NewServer(hmap{}, &ServerOptions{RequestTimeout: time.Hour})
+	s.mu.Lock()
+	ts := s.checkAndAssign(jmessages{{V: Version, ID: json.RawMessage("1"), M: "nonesuch"}})
+	s.mu.Unlock()
+	if len(ts) != 1 || code.FromError(ts[0].err) != code.MethodNotFound {
+		t.Fatalf("checkAndAssign: got %+v, want one MethodNotFound task", ts)
+	}
+	if err := ts[0].ctx.Err(); err != context.Canceled {
+		t.Errorf("Task context: got error %v, want %v", err, context.Canceled)
+	}
+}
+
 // Verify that a batch request gets a batch reply, even if it is only a single
 // request. The Client never sends requests like that, but the server needs to
 // cope with it correctly.
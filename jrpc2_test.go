@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -367,6 +370,222 @@ func TestServerStopCancellation(t *testing.T) {
 	}
 }
 
+// Verify that Shutdown allows in-flight requests to complete before the server
+// stops, and that requests arriving after Shutdown are rejected.
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loc := server.NewLocal(handler.Map{
+		"Slow": handler.New(func(ctx context.Context) (bool, error) {
+			close(started)
+			<-release
+			return true, nil
+		}),
+		"OK": testOK,
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{Concurrency: 2},
+	})
+	defer loc.Close()
+	s, c := loc.Server, loc.Client
+	ctx := context.Background()
+
+	rspc := make(chan error, 1)
+	go func() {
+		var ok bool
+		err := c.CallResult(ctx, "Slow", nil, &ok)
+		if err == nil && !ok {
+			err = errors.New("wrong result")
+		}
+		rspc <- err
+	}()
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	// While the slow call is in flight, new requests should be rejected.
+	for {
+		var got string
+		err := c.CallResult(ctx, "OK", nil, &got)
+		if err != nil {
+			if ec := code.FromError(err); ec != code.SystemError {
+				t.Errorf("Call(OK) during shutdown: got %v (%v), want %v", err, ec, code.SystemError)
+			}
+			break
+		}
+		// The shutdown may not have taken effect yet; try again.
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned early: %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-rspc; err != nil {
+		t.Errorf("Call(Slow): unexpected error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: unexpected error: %v", err)
+	}
+	if stat := s.WaitStatus(); !stat.Stopped() {
+		t.Errorf("Server status: got %+v, want stopped", stat)
+	}
+}
+
+// Verify that if the context given to Shutdown ends before the server is
+// idle, in-flight requests are cancelled.
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	loc := server.NewLocal(handler.Map{
+		"Hang": handler.New(func(ctx context.Context) (bool, error) {
+			close(started)
+			<-ctx.Done()
+			return true, ctx.Err()
+		}),
+	}, nil)
+	defer loc.Close()
+	s, c := loc.Server, loc.Client
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), "Hang", nil)
+		errc <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := <-errc; code.FromError(err) != code.Cancelled {
+		t.Errorf("Call(Hang): got %v, want code %v", err, code.Cancelled)
+	}
+}
+
+// Verify that per-method concurrency limits are enforced and reported.
+func TestMethodConcurrency(t *testing.T) {
+	const tooBusy = code.Code(-32000)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loc := server.NewLocal(handler.Map{
+		"Index.Rebuild": handler.New(func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		}),
+		"Status.Get": testOK,
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			Concurrency:       4,
+			MethodConcurrency: map[string]int{"Index.*": 1, "Bogus": 0},
+			MethodLimitCode:   tooBusy,
+		},
+	})
+	defer loc.Close()
+	s, c := loc.Server, loc.Client
+	ctx := context.Background()
+
+	if diff := cmp.Diff(map[string]int{"Index.*": 1}, s.ServerInfo().Limits); diff != "" {
+		t.Errorf("Wrong limits: (-want, +got)\n%s", diff)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Call(ctx, "Index.Rebuild", nil)
+		errc <- err
+	}()
+	<-started
+
+	// A second call to the limited method should be rejected.
+	if _, err := c.Call(ctx, "Index.Rebuild", nil); code.FromError(err) != tooBusy {
+		t.Errorf("Call(Index.Rebuild): got %v, want code %v", err, tooBusy)
+	}
+
+	// Calls to other methods should not be affected.
+	var got string
+	if err := c.CallResult(ctx, "Status.Get", nil, &got); err != nil {
+		t.Errorf("Call(Status.Get): unexpected error: %v", err)
+	}
+
+	close(release)
+	if err := <-errc; err != nil {
+		t.Errorf("Call(Index.Rebuild): unexpected error: %v", err)
+	}
+	if n := s.ServerInfo().Counter["rpc.limitRejected"]; n != 1 {
+		t.Errorf("Rejection count: got %d, want 1", n)
+	}
+}
+
+// Verify that the server enforces limits on its inbound queue.
+func TestQueueLimits(t *testing.T) {
+	newLocal := func(reject bool) (server.Local, chan struct{}, chan struct{}) {
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		loc := server.NewLocal(handler.Map{
+			"Slow": handler.New(func(ctx context.Context) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}),
+		}, &server.LocalOptions{
+			Server: &jrpc2.ServerOptions{
+				Concurrency:      4,
+				MaxQueueRequests: 1,
+				RejectOverload:   reject,
+			},
+		})
+		return loc, started, release
+	}
+	ctx := context.Background()
+
+	t.Run("Reject", func(t *testing.T) {
+		loc, started, release := newLocal(true)
+		defer loc.Close()
+
+		errc := make(chan error, 1)
+		go func() { _, err := loc.Client.Call(ctx, "Slow", nil); errc <- err }()
+		<-started
+
+		if _, err := loc.Client.Call(ctx, "Slow", nil); code.FromError(err) != code.Overloaded {
+			t.Errorf("Call(Slow): got %v, want code %v", err, code.Overloaded)
+		}
+		close(release)
+		if err := <-errc; err != nil {
+			t.Errorf("Call(Slow): unexpected error: %v", err)
+		}
+		if n := loc.Server.ServerInfo().Counter["rpc.rejected"]; n != 1 {
+			t.Errorf("Rejection count: got %d, want 1", n)
+		}
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		loc, started, release := newLocal(false)
+		defer loc.Close()
+
+		errc := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { _, err := loc.Client.Call(ctx, "Slow", nil); errc <- err }()
+		}
+		<-started
+
+		// The second call should not start until the first has completed.
+		select {
+		case <-started:
+			t.Error("Second call started while the queue was full")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		for i := 0; i < 2; i++ {
+			if err := <-errc; err != nil {
+				t.Errorf("Call(Slow): unexpected error: %v", err)
+			}
+		}
+	})
+}
+
 // Test that a handler can cancel an in-flight request with jrpc2.CancelRequest.
 func TestHandlerCancel(t *testing.T) {
 	ready := make(chan struct{})
@@ -757,6 +976,264 @@ func TestPushCall(t *testing.T) {
 	}
 }
 
+// Verify that server-side callbacks respect the context and the lifetime of
+// the client connection.
+func TestCallbackCancel(t *testing.T) {
+	release := make(chan struct{})
+	loc := server.NewLocal(make(handler.Map), &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{AllowPush: true},
+		Client: &jrpc2.ClientOptions{
+			OnCallback: func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+				if req.Method() == "slow" {
+					<-release
+				}
+				return req.Method(), nil
+			},
+		},
+	})
+	defer loc.Close()
+	s := loc.Server
+
+	// A callback whose context ends before the client replies is abandoned.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if rsp, err := s.Callback(ctx, "slow", nil); err != context.DeadlineExceeded {
+		t.Errorf("Callback(slow): got %v, %v; want %v", rsp, err, context.DeadlineExceeded)
+	}
+
+	// The late reply to the abandoned call does not disturb later callbacks.
+	close(release)
+	if rsp, err := s.Callback(context.Background(), "fast", nil); err != nil {
+		t.Errorf("Callback(fast): unexpected error: %v", err)
+	} else if got := rsp.ResultString(); got != `"fast"` {
+		t.Errorf("Callback(fast): got %s, want %q", got, "fast")
+	}
+
+	// A callback pending when the server stops reports cancellation.
+	hold := make(chan struct{})
+	arrived := make(chan struct{})
+	loc2 := server.NewLocal(make(handler.Map), &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{AllowPush: true},
+		Client: &jrpc2.ClientOptions{
+			OnCallback: func(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+				close(arrived)
+				<-hold
+				return nil, nil
+			},
+		},
+	})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := loc2.Server.Callback(context.Background(), "hang", nil)
+		errc <- err
+	}()
+	<-arrived // the callback has reached the client
+	loc2.Server.Stop()
+	if err := <-errc; code.FromError(err) != code.Cancelled {
+		t.Errorf("Callback(hang): got error %v, want code %v", err, code.Cancelled)
+	}
+	close(hold)
+	loc2.Close()
+}
+
+// Verify that server requests can be routed to handlers that run
+// concurrently, and may call back into the server.
+func TestConcurrentCallbacks(t *testing.T) {
+	var cli *jrpc2.Client
+	cancelled := make(chan struct{})
+	loc := server.NewLocal(handler.Map{
+		"Answer": handler.New(func(context.Context) (int, error) { return 42, nil }),
+		"Ask": handler.New(func(ctx context.Context) (int, error) {
+			rsp, err := jrpc2.PushCall(ctx, "Query", nil)
+			if err != nil {
+				return 0, err
+			}
+			var v int
+			err = rsp.UnmarshalResult(&v)
+			return v, err
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			AllowPush:       true,
+			CancelCallbacks: true,
+			Concurrency:     2,
+		},
+		Client: &jrpc2.ClientOptions{
+			CallbackConcurrency: 2,
+			Callbacks: handler.Map{
+				// Re-enter the server while handling a callback.
+				"Query": handler.New(func(ctx context.Context) (int, error) {
+					var v int
+					err := cli.CallResult(ctx, "Answer", nil, &v)
+					return v, err
+				}),
+				"Block": handler.New(func(ctx context.Context) error {
+					<-ctx.Done()
+					close(cancelled)
+					return ctx.Err()
+				}),
+			},
+		},
+	})
+	defer loc.Close()
+	cli = loc.Client
+	ctx := context.Background()
+
+	var got int
+	if err := cli.CallResult(ctx, "Ask", nil, &got); err != nil {
+		t.Errorf("Call(Ask): unexpected error: %v", err)
+	} else if got != 42 {
+		t.Errorf("Call(Ask): got %d, want 42", got)
+	}
+
+	// A method with no handler reports an error to the server.
+	if rsp, err := loc.Server.Callback(ctx, "Nonesuch", nil); code.FromError(err) != code.MethodNotFound {
+		t.Errorf("Callback(Nonesuch): got %v, %v; want code %v", rsp, err, code.MethodNotFound)
+	}
+
+	// An abandoned callback is cancelled on the client.
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if rsp, err := loc.Server.Callback(tctx, "Block", nil); err != context.DeadlineExceeded {
+		t.Errorf("Callback(Block): got %v, %v; want %v", rsp, err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("Callback(Block) was not cancelled on the client")
+	}
+}
+
+// Verify that progress notifications are delivered to the calls they belong to.
+func TestProgress(t *testing.T) {
+	var notes []string
+	loc := server.NewLocal(handler.Map{
+		"Work": handler.New(func(ctx context.Context) (string, error) {
+			for i := 1; i <= 3; i++ {
+				if err := jrpc2.ReportProgress(ctx, i); err != nil {
+					return "", err
+				}
+			}
+			return "done", nil
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{AllowPush: true},
+		Client: &jrpc2.ClientOptions{
+			OnNotify: func(req *jrpc2.Request) {
+				notes = append(notes, req.Method())
+			},
+		},
+	})
+	defer loc.Close()
+	c := loc.Client
+
+	var got []string
+	ctx := jrpc2.WithProgress(context.Background(), func(id string, value json.RawMessage) {
+		got = append(got, id+":"+string(value))
+	})
+	var rsp string
+	if err := c.CallResult(ctx, "Work", nil, &rsp); err != nil {
+		t.Fatalf("Call(Work): unexpected error: %v", err)
+	} else if rsp != "done" {
+		t.Errorf("Call(Work): got %q, want done", rsp)
+	}
+	if diff := cmp.Diff([]string{"1:1", "1:2", "1:3"}, got); diff != "" {
+		t.Errorf("Progress: (-want, +got)\n%s", diff)
+	}
+
+	// Without a progress hook, the notifications go to OnNotify.
+	if err := c.CallResult(context.Background(), "Work", nil, &rsp); err != nil {
+		t.Fatalf("Call(Work): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"rpc.progress", "rpc.progress", "rpc.progress"}, notes); diff != "" {
+		t.Errorf("Notifications: (-want, +got)\n%s", diff)
+	}
+}
+
+// Verify that subscriptions deliver events from the server to the client, and
+// end when either side closes them.
+func TestSubscriptions(t *testing.T) {
+	subs := make(chan *jrpc2.Subscription, 1)
+	loc := server.NewLocal(handler.Map{
+		"Watch": handler.New(func(ctx context.Context, arg struct{ N int }) (string, error) {
+			sub, err := jrpc2.NewSubscription(ctx)
+			if err != nil {
+				return "", err
+			}
+			go func() {
+				for i := 1; i <= arg.N; i++ {
+					if err := sub.Notify(context.Background(), i); err != nil {
+						t.Errorf("Notify %d failed: %v", i, err)
+					}
+				}
+				subs <- sub
+			}()
+			return sub.ID(), nil
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{AllowPush: true},
+		Client: &jrpc2.ClientOptions{
+			OnNotify: func(req *jrpc2.Request) {
+				t.Errorf("Client received unexpected notification: %v", req.Method())
+			},
+		},
+	})
+	defer loc.Close()
+	ctx := context.Background()
+
+	recv := func(t *testing.T, sub *jrpc2.Subscriber, n int) {
+		t.Helper()
+		for i := 1; i <= n; i++ {
+			var got int
+			if err := json.Unmarshal(<-sub.Events(), &got); err != nil {
+				t.Errorf("Invalid event: %v", err)
+			} else if got != i {
+				t.Errorf("Event: got %d, want %d", got, i)
+			}
+		}
+	}
+
+	t.Run("ServerClose", func(t *testing.T) {
+		sub, err := loc.Client.Subscribe(ctx, "Watch", handler.Obj{"N": 3})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		ssub := <-subs
+		if err := ssub.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+		recv(t, sub, 3)
+		if v, ok := <-sub.Events(); ok {
+			t.Errorf("Unexpected event after close: %s", v)
+		}
+		if err := ssub.Notify(ctx, 4); err != jrpc2.ErrSubscriptionClosed {
+			t.Errorf("Notify after close: got %v, want %v", err, jrpc2.ErrSubscriptionClosed)
+		}
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		sub, err := loc.Client.Subscribe(ctx, "Watch", handler.Obj{"N": 2})
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		recv(t, sub, 2)
+		ssub := <-subs
+		if err := sub.Unsubscribe(ctx); err != nil {
+			t.Errorf("Unsubscribe failed: %v", err)
+		}
+		<-ssub.Done()
+	})
+
+	t.Run("ConnectionClosed", func(t *testing.T) {
+		if _, err := loc.Client.Subscribe(ctx, "Watch", handler.Obj{"N": 0}); err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		ssub := <-subs
+		loc.Client.Close()
+		<-ssub.Done()
+	})
+}
+
 // Verify that a server push after the client closes does not trigger a panic.
 func TestDeadServerPush(t *testing.T) {
 	loc := server.NewLocal(make(handler.Map), &server.LocalOptions{
@@ -800,54 +1277,324 @@ func TestContextPlumbing(t *testing.T) {
 	}
 }
 
-// Verify that the request-checking hook works.
-func TestRequestHook(t *testing.T) {
-	const wantResponse = "Hey girl"
-	const wantToken = "OK"
-
+// Verify that server interceptors wrap handlers in the correct order, and can
+// modify the context, short-circuit requests, and rewrite errors.
+func TestInterceptors(t *testing.T) {
+	type ctxKey struct{}
+	var trace []string
 	loc := server.NewLocal(handler.Map{
-		"Test": handler.New(func(ctx context.Context) (string, error) {
-			return wantResponse, nil
+		"Value": handler.New(func(ctx context.Context) (string, error) {
+			trace = append(trace, "handler")
+			return ctx.Value(ctxKey{}).(string), nil
+		}),
+		"Cached": handler.New(func(ctx context.Context) (string, error) {
+			t.Error("Cached handler should not be called")
+			return "from handler", nil
+		}),
+		"Fail": handler.New(func(ctx context.Context) error {
+			return errors.New("plain error")
 		}),
 	}, &server.LocalOptions{
-		// Enable auth checking and context decoding for the server.
 		Server: &jrpc2.ServerOptions{
-			DecodeContext: jctx.Decode,
-			CheckRequest: func(ctx context.Context, req *jrpc2.Request) error {
-				var token []byte
-				switch err := jctx.UnmarshalMetadata(ctx, &token); err {
-				case nil:
-					t.Logf("Metadata present: value=%q", string(token))
-				case jctx.ErrNoMetadata:
-					t.Log("Metadata not set")
-				default:
-					return err
-				}
-				if s := string(token); s != wantToken {
-					return jrpc2.Errorf(notAuthorized, "not authorized")
-				}
-				return nil
+			Interceptors: []jrpc2.Interceptor{
+				func(ctx context.Context, req *jrpc2.Request, next jrpc2.Handler) (interface{}, error) {
+					trace = append(trace, "outer")
+					v, err := next.Handle(ctx, req)
+					if err != nil && code.FromError(err) == code.SystemError {
+						return nil, jrpc2.Errorf(notAuthorized, "rewritten: %v", err)
+					}
+					return v, err
+				},
+				func(ctx context.Context, req *jrpc2.Request, next jrpc2.Handler) (interface{}, error) {
+					trace = append(trace, "inner")
+					if req.Method() == "Cached" {
+						return "from cache", nil
+					}
+					return next.Handle(context.WithValue(ctx, ctxKey{}, "from context"), req)
+				},
 			},
 		},
-
-		// Enable context encoding for the client.
-		Client: &jrpc2.ClientOptions{
-			EncodeContext: jctx.Encode,
-		},
 	})
 	defer loc.Close()
 	c := loc.Client
+	ctx := context.Background()
 
-	// Call without a token and verify that we get an error.
-	t.Run("NoToken", func(t *testing.T) {
-		var rsp string
-		err := c.CallResult(context.Background(), "Test", nil, &rsp)
-		if err == nil {
-			t.Errorf("Call(Test): got %q, wanted error", rsp)
-		} else if ec := code.FromError(err); ec != notAuthorized {
-			t.Errorf("Call(Test): got code %v, want %v", ec, notAuthorized)
+	for _, test := range []struct {
+		method, want string
+		trace        []string
+	}{
+		{"Value", "from context", []string{"outer", "inner", "handler"}},
+		{"Cached", "from cache", []string{"outer", "inner"}},
+	} {
+		trace = nil
+		var got string
+		if err := c.CallResult(ctx, test.method, nil, &got); err != nil {
+			t.Errorf("Call(%s): unexpected error: %v", test.method, err)
+		} else if got != test.want {
+			t.Errorf("Call(%s): got %q, want %q", test.method, got, test.want)
 		}
-	})
+		if diff := cmp.Diff(test.trace, trace); diff != "" {
+			t.Errorf("Call(%s) trace: (-want, +got)\n%s", test.method, diff)
+		}
+	}
+
+	if _, err := c.Call(ctx, "Fail", nil); code.FromError(err) != notAuthorized {
+		t.Errorf("Call(Fail): got %v, want code %v", err, notAuthorized)
+	}
+}
+
+// Verify that client interceptors wrap calls, batches, and notifications in
+// the correct order, and can attach metadata, observe results, and
+// short-circuit requests.
+func TestClientInterceptors(t *testing.T) {
+	var trace []string
+	notified := make(chan string, 1)
+	loc := server.NewLocal(handler.Map{
+		"Token": handler.New(func(ctx context.Context) (string, error) {
+			var token string
+			err := jctx.UnmarshalMetadata(ctx, &token)
+			return token, err
+		}),
+		"Note": handler.New(func(ctx context.Context, msg []string) error {
+			notified <- strings.Join(msg, " ")
+			return nil
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{DecodeContext: jctx.Decode},
+		Client: &jrpc2.ClientOptions{
+			EncodeContext: jctx.Encode,
+			Interceptors: []jrpc2.ClientInterceptor{
+				func(ctx context.Context, specs []jrpc2.Spec, next jrpc2.Invoker) ([]*jrpc2.Pending, error) {
+					for _, spec := range specs {
+						trace = append(trace, "outer "+spec.Method)
+					}
+					ps, err := next(ctx, specs)
+					for _, p := range ps {
+						p.OnDone(func(rsp *jrpc2.Response) {
+							var s string
+							rsp.UnmarshalResult(&s)
+							trace = append(trace, "result "+s)
+						})
+					}
+					return ps, err
+				},
+				func(ctx context.Context, specs []jrpc2.Spec, next jrpc2.Invoker) ([]*jrpc2.Pending, error) {
+					if specs[0].Method == "Blocked" {
+						return nil, errors.New("blocked by interceptor")
+					}
+					ctx, err := jctx.WithMetadata(ctx, "secret")
+					if err != nil {
+						return nil, err
+					}
+					return next(ctx, specs)
+				},
+			},
+		},
+	})
+	defer loc.Close()
+	c := loc.Client
+	ctx := context.Background()
+
+	var token string
+	if err := c.CallResult(ctx, "Token", nil, &token); err != nil {
+		t.Errorf("Call(Token): unexpected error: %v", err)
+	} else if token != "secret" {
+		t.Errorf("Call(Token): got %q, want %q", token, "secret")
+	}
+
+	if _, err := c.Batch(ctx, []jrpc2.Spec{
+		{Method: "Token"},
+		{Method: "Note", Params: []string{"hello"}, Notify: true},
+	}); err != nil {
+		t.Errorf("Batch: unexpected error: %v", err)
+	}
+	if got := <-notified; got != "hello" {
+		t.Errorf("Batch notification: got %q, want %q", got, "hello")
+	}
+
+	if err := c.Notify(ctx, "Note", []string{"world"}); err != nil {
+		t.Errorf("Notify: unexpected error: %v", err)
+	}
+	if got := <-notified; got != "world" {
+		t.Errorf("Notify: got %q, want %q", got, "world")
+	}
+
+	if _, err := c.Call(ctx, "Blocked", nil); err == nil || err.Error() != "blocked by interceptor" {
+		t.Errorf("Call(Blocked): got %v, want interceptor error", err)
+	}
+
+	// Calls started asynchronously also pass through the interceptors.
+	p, err := c.Start(ctx, "Token", nil)
+	if err != nil {
+		t.Fatalf("Start(Token): unexpected error: %v", err)
+	}
+	if err := p.WaitResult(&token); err != nil {
+		t.Errorf("Wait(Token): unexpected error: %v", err)
+	} else if token != "secret" {
+		t.Errorf("Wait(Token): got %q, want %q", token, "secret")
+	}
+	if _, err := c.Start(ctx, "Blocked", nil); err == nil {
+		t.Error("Start(Blocked): got nil error, want interceptor error")
+	}
+
+	want := []string{
+		"outer Token", "result secret",
+		"outer Token", "outer Note", "result secret",
+		"outer Note",
+		"outer Blocked",
+		"outer Token", "result secret",
+		"outer Blocked",
+	}
+	if diff := cmp.Diff(want, trace); diff != "" {
+		t.Errorf("Trace: (-want, +got)\n%s", diff)
+	}
+}
+
+// Verify that the server recovers from panics in handlers when requested.
+func TestRecoverPanics(t *testing.T) {
+	loc := server.NewLocal(handler.Map{
+		"Panic": handler.New(func(ctx context.Context) error {
+			panic("ouch")
+		}),
+		"OK": testOK,
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			RecoverPanics:   true,
+			PanicStackTrace: true,
+		},
+	})
+	defer loc.Close()
+	c := loc.Client
+	ctx := context.Background()
+
+	_, err := c.Call(ctx, "Panic", nil)
+	if e, ok := err.(*jrpc2.Error); !ok {
+		t.Fatalf("Call(Panic): got %v, want *jrpc2.Error", err)
+	} else if e.Code() != code.InternalError {
+		t.Errorf("Call(Panic): got code %v, want %v", e.Code(), code.InternalError)
+	} else {
+		var data struct {
+			Panic, Stack string
+		}
+		if err := e.UnmarshalData(&data); err != nil {
+			t.Errorf("Unmarshaling error data: %v", err)
+		} else if data.Panic != "ouch" || data.Stack == "" {
+			t.Errorf("Error data: got %+v, want panic and stack", data)
+		}
+	}
+
+	// The server should still be running after the panic.
+	var got string
+	if err := c.CallResult(ctx, "OK", nil, &got); err != nil {
+		t.Errorf("Call(OK): unexpected error: %v", err)
+	}
+	if n := loc.Server.ServerInfo().Counter["rpc.panics"]; n != 1 {
+		t.Errorf("Panic count: got %d, want 1", n)
+	}
+}
+
+// Verify that server-imposed request timeouts are applied.
+func TestRequestTimeouts(t *testing.T) {
+	hang := handler.New(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	loc := server.NewLocal(handler.Map{
+		"Hang": hang,
+		"Ignore": handler.New(func(ctx context.Context) (bool, error) {
+			<-ctx.Done()
+			return true, nil // ignore the expiration
+		}),
+		"Deadline": handler.New(func(ctx context.Context) (time.Duration, error) {
+			dl, ok := ctx.Deadline()
+			if !ok {
+				return 0, errors.New("no deadline was set")
+			}
+			return time.Until(dl), nil
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			Concurrency:       4,
+			DecodeContext:     jctx.Decode,
+			RequestTimeout:    5 * time.Millisecond,
+			MethodTimeout:     map[string]time.Duration{"Dead*": 0},
+			MaxRequestTimeout: time.Minute,
+		},
+		Client: &jrpc2.ClientOptions{EncodeContext: jctx.Encode},
+	})
+	defer loc.Close()
+	c := loc.Client
+	ctx := context.Background()
+
+	for _, method := range []string{"Hang", "Ignore"} {
+		if _, err := c.Call(ctx, method, nil); code.FromError(err) != code.DeadlineExceeded {
+			t.Errorf("Call(%s): got %v, want code %v", method, err, code.DeadlineExceeded)
+		}
+	}
+	if n := loc.Server.ServerInfo().Counter["rpc.timeouts"]; n != 2 {
+		t.Errorf("Timeout count: got %d, want 2", n)
+	}
+
+	// The maximum applies to a method with no other timeout, and caps the
+	// deadline requested by the client.
+	cctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	var left time.Duration
+	if err := c.CallResult(cctx, "Deadline", nil, &left); err != nil {
+		t.Errorf("Call(Deadline): unexpected error: %v", err)
+	} else if left > time.Minute {
+		t.Errorf("Call(Deadline): deadline in %v, want at most %v", left, time.Minute)
+	}
+}
+
+// Verify that the request-checking hook works.
+func TestRequestHook(t *testing.T) {
+	const wantResponse = "Hey girl"
+	const wantToken = "OK"
+
+	loc := server.NewLocal(handler.Map{
+		"Test": handler.New(func(ctx context.Context) (string, error) {
+			return wantResponse, nil
+		}),
+	}, &server.LocalOptions{
+		// Enable auth checking and context decoding for the server.
+		Server: &jrpc2.ServerOptions{
+			DecodeContext: jctx.Decode,
+			CheckRequest: func(ctx context.Context, req *jrpc2.Request) error {
+				var token []byte
+				switch err := jctx.UnmarshalMetadata(ctx, &token); err {
+				case nil:
+					t.Logf("Metadata present: value=%q", string(token))
+				case jctx.ErrNoMetadata:
+					t.Log("Metadata not set")
+				default:
+					return err
+				}
+				if s := string(token); s != wantToken {
+					return jrpc2.Errorf(notAuthorized, "not authorized")
+				}
+				return nil
+			},
+		},
+
+		// Enable context encoding for the client.
+		Client: &jrpc2.ClientOptions{
+			EncodeContext: jctx.Encode,
+		},
+	})
+	defer loc.Close()
+	c := loc.Client
+
+	// Call without a token and verify that we get an error.
+	t.Run("NoToken", func(t *testing.T) {
+		var rsp string
+		err := c.CallResult(context.Background(), "Test", nil, &rsp)
+		if err == nil {
+			t.Errorf("Call(Test): got %q, wanted error", rsp)
+		} else if ec := code.FromError(err); ec != notAuthorized {
+			t.Errorf("Call(Test): got code %v, want %v", ec, notAuthorized)
+		}
+	})
 
 	// Call with a valid token and verify that we get a response.
 	t.Run("GoodToken", func(t *testing.T) {
@@ -1011,3 +1758,869 @@ type buggyChannel struct {
 func (buggyChannel) Send([]byte) error       { panic("should not be called") }
 func (b buggyChannel) Recv() ([]byte, error) { return []byte(b.data), b.err }
 func (buggyChannel) Close() error            { return nil }
+
+// Verify that batches are executed in order when requested.
+func TestOrderedBatches(t *testing.T) {
+	newServer := func(opts *jrpc2.ServerOptions) (server.Local, *[]int) {
+		var mu sync.Mutex
+		var got []int
+		loc := server.NewLocal(handler.Map{
+			"Put": handler.New(func(ctx context.Context, req []int) error {
+				time.Sleep(time.Duration(req[1]) * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, req[0])
+				return nil
+			}),
+			"Fail": handler.New(func(context.Context) error {
+				return errors.New("failed")
+			}),
+		}, &server.LocalOptions{Server: opts})
+		return loc, &got
+	}
+	specs := []jrpc2.Spec{
+		{Method: "Put", Params: []int{1, 20}},
+		{Method: "Put", Params: []int{2, 0}},
+		{Method: "Fail"},
+		{Method: "Put", Params: []int{3, 0}},
+	}
+	checkCodes := func(t *testing.T, rsps []*jrpc2.Response, want ...code.Code) {
+		t.Helper()
+		var got []code.Code
+		for _, rsp := range rsps {
+			if e := rsp.Error(); e != nil {
+				got = append(got, e.Code())
+			} else {
+				got = append(got, code.NoError)
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Batch codes: (-want, +got)\n%s", diff)
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("Marker", func(t *testing.T) {
+		loc, got := newServer(&jrpc2.ServerOptions{Concurrency: 4})
+		defer loc.Close()
+		marker := jrpc2.Spec{Method: "rpc.ordered", Params: map[string]bool{"stopOnError": true}, Notify: true}
+		rsps, err := loc.Client.Batch(ctx, append([]jrpc2.Spec{marker}, specs...))
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		checkCodes(t, rsps, code.NoError, code.NoError, code.SystemError, code.Skipped)
+		if diff := cmp.Diff([]int{1, 2}, *got); diff != "" {
+			t.Errorf("Execution order: (-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		loc, got := newServer(&jrpc2.ServerOptions{Concurrency: 4, OrderedBatches: true})
+		defer loc.Close()
+		rsps, err := loc.Client.Batch(ctx, specs)
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		checkCodes(t, rsps, code.NoError, code.NoError, code.SystemError, code.NoError)
+		if diff := cmp.Diff([]int{1, 2, 3}, *got); diff != "" {
+			t.Errorf("Execution order: (-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("StopUnordered", func(t *testing.T) {
+		// StopBatchOnError has no effect on a batch that is not ordered.
+		loc, got := newServer(&jrpc2.ServerOptions{Concurrency: 4, StopBatchOnError: true})
+		defer loc.Close()
+		rsps, err := loc.Client.Batch(ctx, []jrpc2.Spec{
+			{Method: "Put", Params: []int{1, 0}},
+			{Method: "Nope"},
+			{Method: "Put", Params: []int{2, 0}},
+		})
+		if err != nil {
+			t.Fatalf("Batch failed: %v", err)
+		}
+		checkCodes(t, rsps, code.NoError, code.MethodNotFound, code.NoError)
+		if n := len(*got); n != 2 {
+			t.Errorf("Executed %d requests, want 2", n)
+		}
+	})
+}
+
+// Verify that requests with the same ordering key execute in order, while
+// requests with different keys run concurrently.
+func TestOrderingKeys(t *testing.T) {
+	var mu sync.Mutex
+	var trace []string
+	loc := server.NewLocal(handler.Map{
+		"Put": handler.New(func(ctx context.Context, req []string) error {
+			if req[1] == "slow" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			trace = append(trace, req[0]+":"+req[1])
+			return nil
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			Concurrency: 4,
+			OrderingKey: func(_ context.Context, req *jrpc2.Request) string {
+				var args []string
+				if err := req.UnmarshalParams(&args); err != nil {
+					return ""
+				}
+				return args[0]
+			},
+		},
+	})
+	defer loc.Close()
+
+	rsps, err := loc.Client.Batch(context.Background(), []jrpc2.Spec{
+		{Method: "Put", Params: []string{"a", "slow"}},
+		{Method: "Put", Params: []string{"b", "first"}},
+		{Method: "Put", Params: []string{"a", "next"}},
+		{Method: "Put", Params: []string{"b", "second"}},
+	})
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	for i, rsp := range rsps {
+		if err := rsp.Error(); err != nil {
+			t.Errorf("Response %d: unexpected error: %v", i, err)
+		}
+	}
+	want := []string{"b:first", "b:second", "a:slow", "a:next"}
+	if diff := cmp.Diff(want, trace); diff != "" {
+		t.Errorf("Execution order: (-want, +got)\n%s", diff)
+	}
+}
+
+// Verify that a keyed notification waiting behind a keyed request does not
+// block the server from dispatching a later batch.
+func TestOrderingKeyNotify(t *testing.T) {
+	var mu sync.Mutex
+	var trace []string
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, s)
+	}
+	loc := server.NewLocal(handler.Map{
+		"Slow": handler.New(func(context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			record("Slow")
+			return nil
+		}),
+		"Note": handler.New(func(context.Context) error { record("Note"); return nil }),
+		"Fast": handler.New(func(context.Context) error { record("Fast"); return nil }),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			Concurrency: 4,
+			OrderingKey: func(context.Context, *jrpc2.Request) string { return "k" },
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx := context.Background()
+		p, err := loc.Client.Start(ctx, "Slow", nil)
+		if err != nil {
+			t.Errorf("Start(Slow) failed: %v", err)
+			return
+		}
+		if err := loc.Client.Notify(ctx, "Note", nil); err != nil {
+			t.Errorf("Notify(Note) failed: %v", err)
+		}
+		if _, err := loc.Client.Call(ctx, "Fast", nil); err != nil {
+			t.Errorf("Call(Fast) failed: %v", err)
+		}
+		if _, err := p.Wait(); err != nil {
+			t.Errorf("Wait(Slow) failed: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not complete the calls")
+	}
+	loc.Close()
+
+	want := []string{"Slow", "Note", "Fast"}
+	if diff := cmp.Diff(want, trace); diff != "" {
+		t.Errorf("Execution order: (-want, +got)\n%s", diff)
+	}
+}
+
+// Verify that the server rejects oversized messages and batches without
+// dropping the connection.
+func TestMessageLimits(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	cli := channel.Line(cr, cw)
+	srv := channel.Limit(channel.Line, 128)(sr, sw)
+	s := jrpc2.NewServer(handler.Map{"X": testOK}, &jrpc2.ServerOptions{
+		MaxBatchSize: 2,
+	}).Start(srv)
+	defer func() {
+		cli.Close()
+		if err := s.Wait(); err != nil {
+			t.Errorf("Server wait: unexpected error %v", err)
+		}
+	}()
+
+	big := `{"jsonrpc":"2.0","id":1,"method":"X","params":["` + strings.Repeat("x", 200) + `"]}`
+	tests := []struct {
+		input, want string
+	}{
+		{big, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"request message of 251 bytes exceeds limit of 128"}}`},
+		{`[{"jsonrpc":"2.0","id":2,"method":"X"},{"jsonrpc":"2.0","id":3,"method":"X"},{"jsonrpc":"2.0","id":4,"method":"X"}]`,
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"request batch of 3 requests exceeds limit of 2"}}`},
+		{`[{"jsonrpc":"2.0","id":5,"method":"X"},{"jsonrpc":"2.0","id":6,"method":"X"}]`,
+			`[{"jsonrpc":"2.0","id":5,"result":"OK"},{"jsonrpc":"2.0","id":6,"result":"OK"}]`},
+	}
+	for _, test := range tests {
+		go func(input string) {
+			if err := cli.Send([]byte(input)); err != nil {
+				t.Errorf("Send failed: %v", err)
+			}
+		}(test.input)
+		raw, err := cli.Recv()
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if got := string(raw); got != test.want {
+			t.Errorf("Request %.40q: got %#q, want %#q", test.input, got, test.want)
+		}
+	}
+}
+
+// Verify that the rpc.discover method reports the methods of the server.
+func TestDiscover(t *testing.T) {
+	loc := server.NewLocal(handler.ServiceMap{
+		"Test": handler.NewService(dummy{}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{
+			Discover: &jrpc2.OpenRPCInfo{Title: "Test", Version: "1.0"},
+			AnnotateMethod: func(doc *jrpc2.MethodDoc) {
+				if doc.Name == "Test.Mul" {
+					doc.Summary = "Multiply two numbers"
+					doc.Examples = []*jrpc2.Example{{
+						Name: "six",
+						Params: []*jrpc2.ExampleValue{
+							{Name: "X", Value: 2},
+							{Name: "Y", Value: 3},
+						},
+						Result: &jrpc2.ExampleValue{Name: "result", Value: 6},
+					}}
+				}
+			},
+		},
+	})
+	defer loc.Close()
+	ctx := context.Background()
+
+	doc, err := jrpc2.RPCDiscover(ctx, loc.Client)
+	if err != nil {
+		t.Fatalf("RPCDiscover failed: %v", err)
+	}
+	if doc.Version != jrpc2.OpenRPCVersion || doc.Info.Title != "Test" {
+		t.Errorf("RPCDiscover: got version %q, info %+v", doc.Version, doc.Info)
+	}
+	methods := make(map[string]*jrpc2.MethodDoc)
+	var names []string
+	for _, m := range doc.Methods {
+		methods[m.Name] = m
+		names = append(names, m.Name)
+	}
+	want := []string{"Test.Add", "Test.Ctx", "Test.Max", "Test.Mul", "Test.Nil", "Test.Ping"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("RPCDiscover methods: (-want, +got)\n%s", diff)
+	}
+
+	mul := methods["Test.Mul"]
+	if mul.Summary == "" || len(mul.Examples) != 1 {
+		t.Errorf("RPCDiscover: Test.Mul was not annotated: %+v", mul)
+	}
+	var params []string
+	for _, p := range mul.Params {
+		params = append(params, p.Name+" "+string(p.Schema))
+	}
+	if diff := cmp.Diff([]string{`X {"type":"integer"}`, `Y {"type":"integer"}`}, params); diff != "" {
+		t.Errorf("RPCDiscover: Test.Mul params: (-want, +got)\n%s", diff)
+	}
+	if max := methods["Test.Max"]; len(max.Params) != 1 || string(max.Result.Schema) != `{"type":"integer"}` {
+		t.Errorf("RPCDiscover: wrong description for Test.Max: %+v", max)
+	}
+
+	// Discovery is disabled by default.
+	loc2 := server.NewLocal(handler.Map{}, nil)
+	defer loc2.Close()
+	if _, err := jrpc2.RPCDiscover(ctx, loc2.Client); code.FromError(err) != code.MethodNotFound {
+		t.Errorf("RPCDiscover: got %v, want code %v", err, code.MethodNotFound)
+	}
+}
+
+func TestBind(t *testing.T) {
+	loc := server.NewLocal(handler.ServiceMap{
+		"Test": handler.NewService(dummy{}),
+	}, nil)
+	defer loc.Close()
+	ctx := context.Background()
+
+	var svc struct {
+		Add   func(context.Context, []int) (int, error)
+		Max   func(context.Context, ...int) (int, error)
+		Mul   func(context.Context, struct{ X, Y int }) (int, error)
+		Nil   func(context.Context) (int, error)
+		Ping  func(context.Context) error                         `jrpc2:"Test.Nil"`
+		Raw   func(context.Context, json.RawMessage) (int, error) `jrpc2:"Test.Add"`
+		Other string
+		Skip  func() `jrpc2:"-"`
+	}
+	if err := loc.Client.Bind("Test", &svc); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	check := func(name string, got int, err error, want int) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if got != want {
+			t.Errorf("%s: got %d, want %d", name, got, want)
+		}
+	}
+	got, err := svc.Add(ctx, []int{1, 2, 3})
+	check("Add", got, err, 6)
+	got, err = svc.Max(ctx, 3, 9, 4)
+	check("Max", got, err, 9)
+	got, err = svc.Mul(ctx, struct{ X, Y int }{6, 7})
+	check("Mul", got, err, 42)
+	got, err = svc.Nil(ctx)
+	check("Nil", got, err, 42)
+	got, err = svc.Raw(ctx, json.RawMessage(`[4, 5]`))
+	check("Raw", got, err, 9)
+	if err := svc.Ping(ctx); err != nil {
+		t.Errorf("Ping: unexpected error: %v", err)
+	}
+	if got, err := svc.Max(ctx); code.FromError(err) != code.InvalidParams {
+		t.Errorf("Max(): got (%d, %v), want code %v", got, err, code.InvalidParams)
+	}
+
+	// Invalid targets and signatures are rejected.
+	for _, bad := range []interface{}{
+		nil,
+		svc,
+		&struct{ F func(int) error }{},
+		&struct{ F func(context.Context) int }{},
+		&struct{ F func(context.Context, int, int) error }{},
+		&struct{ F func(context.Context, string) error }{},
+		&struct{ F func(context.Context, *int) (bool, error) }{},
+		&struct{ F func(context.Context, []byte) error }{},
+	} {
+		if err := loc.Client.Bind("", bad); err == nil {
+			t.Errorf("Bind(%T): got nil, want error", bad)
+		}
+	}
+}
+
+func TestRedialClient(t *testing.T) {
+	ctx := context.Background()
+
+	// Each connection is served by a new server. The first dial fails. The
+	// Block method waits until its connection is dropped by the test, but only
+	// on the first connection that is dialed successfully.
+	var mu sync.Mutex
+	var servers []*jrpc2.Server
+	entered := make(chan struct{}, 1)
+	dial := func(context.Context) (channel.Channel, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if servers == nil {
+			servers = []*jrpc2.Server{}
+			return nil, errors.New("first dial fails")
+		}
+		n := len(servers)
+		cch, sch := channel.Direct()
+		s := jrpc2.NewServer(handler.Map{
+			"Conn": handler.New(func(context.Context) int { return n }),
+			"Block": handler.New(func(ctx context.Context) int {
+				if n == 0 {
+					entered <- struct{}{}
+					<-ctx.Done()
+				}
+				return n
+			}),
+		}, nil).Start(sch)
+		servers = append(servers, s)
+		return cch, nil
+	}
+	drop := func(i int) {
+		mu.Lock()
+		s := servers[i]
+		mu.Unlock()
+		s.Stop()
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		mu.Lock()
+		servers = nil
+		mu.Unlock()
+		var states []string
+		cli := jrpc2.NewRedialClient(dial, &jrpc2.RedialOptions{
+			MinBackoff: time.Millisecond,
+			OnStateChange: func(s jrpc2.ConnState, err error) {
+				states = append(states, s.String())
+			},
+		})
+
+		var n int
+		if err := cli.CallResult(ctx, "Conn", nil, &n); err != nil || n != 0 {
+			t.Errorf("Call Conn: got (%d, %v), want (0, nil)", n, err)
+		}
+
+		// A call in flight when the connection drops fails.
+		go func() { <-entered; drop(0) }()
+		if rsp, err := cli.Call(ctx, "Block", nil); !errors.Is(err, jrpc2.ErrConnLost) {
+			t.Errorf("Call Block: got (%v, %v), want %v", rsp, err, jrpc2.ErrConnLost)
+		}
+
+		// Subsequent calls use a new connection.
+		if err := cli.CallResult(ctx, "Conn", nil, &n); err != nil || n != 1 {
+			t.Errorf("Call Conn: got (%d, %v), want (1, nil)", n, err)
+		}
+		if err := cli.Close(); err != nil {
+			t.Errorf("Close: unexpected error: %v", err)
+		}
+		if _, err := cli.Call(ctx, "Conn", nil); err == nil {
+			t.Error("Call after Close: got nil, want error")
+		}
+		want := []string{
+			"connecting", "disconnected", "connecting", "connected",
+			"disconnected", "connecting", "connected", "closed",
+		}
+		if diff := cmp.Diff(want, states); diff != "" {
+			t.Errorf("Wrong state changes (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		mu.Lock()
+		servers = nil
+		mu.Unlock()
+		cli := jrpc2.NewRedialClient(dial, &jrpc2.RedialOptions{
+			MinBackoff:  time.Millisecond,
+			ReplayCalls: true,
+		})
+		defer cli.Close()
+
+		// A call in flight when the connection drops is sent again.
+		go func() { <-entered; drop(0) }()
+		var n int
+		if err := cli.CallResult(ctx, "Block", nil, &n); err != nil || n != 1 {
+			t.Errorf("Call Block: got (%d, %v), want (1, nil)", n, err)
+		}
+		if s := cli.State(); s != jrpc2.Connected {
+			t.Errorf("State: got %v, want %v", s, jrpc2.Connected)
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		// The server accepts each connection and drops it at once.
+		var dials int32
+		cli := jrpc2.NewRedialClient(func(context.Context) (channel.Channel, error) {
+			atomic.AddInt32(&dials, 1)
+			cch, sch := channel.Direct()
+			sch.Close()
+			return cch, nil
+		}, &jrpc2.RedialOptions{
+			MinBackoff: 10 * time.Millisecond,
+			MaxBackoff: time.Second,
+		})
+		time.Sleep(200 * time.Millisecond)
+		cli.Close()
+
+		// With delays of 10, 20, 40, 80ms, ... there is time for at most 5
+		// dials. Without backoff there would be many more.
+		if n := atomic.LoadInt32(&dials); n > 5 {
+			t.Errorf("Dialed %d times in 200ms, want at most 5", n)
+		}
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var calls int32
+	failing := func(n int32) jrpc2.Handler {
+		return handler.New(func(context.Context) (int32, error) {
+			if c := atomic.AddInt32(&calls, 1); c <= n {
+				return 0, jrpc2.Errorf(code.SystemError, "failure %d", c)
+			}
+			return atomic.LoadInt32(&calls), nil
+		})
+	}
+	loc := server.NewLocal(handler.Map{
+		"Flaky":  failing(2),
+		"Broken": failing(100),
+		"Unsafe": failing(1),
+		"Bad": handler.New(func(context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return jrpc2.Errorf(code.InvalidParams, "bad")
+		}),
+	}, &server.LocalOptions{
+		Client: &jrpc2.ClientOptions{
+			Retry: &jrpc2.RetryPolicy{
+				MaxAttempts: 4,
+				Codes:       []code.Code{code.SystemError},
+				MinBackoff:  time.Millisecond,
+				Jitter:      0.5,
+				Idempotent:  func(method string) bool { return method != "Unsafe" },
+			},
+		},
+	})
+	defer loc.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		method string
+		calls  int32
+		code   code.Code
+	}{
+		{"Flaky", 3, code.NoError},      // succeeds on the third attempt
+		{"Broken", 4, code.SystemError}, // gives up after MaxAttempts
+		{"Unsafe", 1, code.SystemError}, // not idempotent
+		{"Bad", 1, code.InvalidParams},  // not a retryable code
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&calls, 0)
+		_, err := loc.Client.Call(ctx, test.method, nil)
+		if got := code.FromError(err); got != test.code {
+			t.Errorf("Call %q: got error %v, want code %v", test.method, err, test.code)
+		}
+		if got := atomic.LoadInt32(&calls); got != test.calls {
+			t.Errorf("Call %q: got %d attempts, want %d", test.method, got, test.calls)
+		}
+	}
+
+	// A retry is not attempted if the backoff would exceed the deadline.
+	loc2 := server.NewLocal(handler.Map{"Broken": failing(100)}, &server.LocalOptions{
+		Client: &jrpc2.ClientOptions{
+			Retry: &jrpc2.RetryPolicy{
+				MaxAttempts: 5,
+				Codes:       []code.Code{code.SystemError},
+				MinBackoff:  time.Hour,
+			},
+		},
+	})
+	defer loc2.Close()
+	atomic.StoreInt32(&calls, 0)
+	tctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if _, err := loc2.Client.Call(tctx, "Broken", nil); code.FromError(err) != code.SystemError {
+		t.Errorf("Call Broken: got %v, want code %v", err, code.SystemError)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Call Broken: got %d attempts, want 1", got)
+	}
+
+	// Server errors with the codes of context errors are matched by code, and
+	// are not treated as transport errors. Errors in preparing a request are
+	// not retried.
+	var attempts int32
+	replying := func(c code.Code) jrpc2.Handler {
+		return handler.New(func(context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return jrpc2.Errorf(c, "reply")
+		})
+	}
+	loc3 := server.NewLocal(handler.Map{
+		"Timeout": replying(code.DeadlineExceeded),
+		"Cancel":  replying(code.Cancelled),
+	}, &server.LocalOptions{
+		Client: &jrpc2.ClientOptions{
+			Retry: &jrpc2.RetryPolicy{
+				MaxAttempts: 3,
+				Codes:       []code.Code{code.DeadlineExceeded},
+				Transport:   true,
+				MinBackoff:  time.Millisecond,
+			},
+			Interceptors: []jrpc2.ClientInterceptor{
+				func(ctx context.Context, specs []jrpc2.Spec, next jrpc2.Invoker) ([]*jrpc2.Pending, error) {
+					atomic.AddInt32(&attempts, 1)
+					return next(ctx, specs)
+				},
+			},
+		},
+	})
+	defer loc3.Close()
+	for _, test := range []struct {
+		method   string
+		params   interface{}
+		attempts int32 // requests issued by the client
+		calls    int32 // requests received by the server
+	}{
+		{"Timeout", nil, 3, 3},
+		{"Cancel", nil, 1, 1},
+		{"Timeout", []interface{}{make(chan int)}, 1, 0}, // cannot be marshaled
+	} {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&attempts, 0)
+		if _, err := loc3.Client.Call(ctx, test.method, test.params); err == nil {
+			t.Errorf("Call %q: got nil error, want error", test.method)
+		}
+		if got := atomic.LoadInt32(&attempts); got != test.attempts {
+			t.Errorf("Call %q: got %d attempts, want %d", test.method, got, test.attempts)
+		}
+		if got := atomic.LoadInt32(&calls); got != test.calls {
+			t.Errorf("Call %q: got %d calls, want %d", test.method, got, test.calls)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	// Each connection is served by a new server that reports its address.
+	// Dialing an address marked down fails.
+	var mu sync.Mutex
+	down := make(map[string]bool)
+	servers := make(map[string]*jrpc2.Server)
+	release := make(chan struct{})
+	dial := func(_ context.Context, addr string) (channel.Channel, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[addr] {
+			return nil, fmt.Errorf("%s is down", addr)
+		}
+		cch, sch := channel.Direct()
+		servers[addr] = jrpc2.NewServer(handler.Map{
+			"Who": handler.New(func(context.Context) string { return addr }),
+			"Block": handler.New(func(context.Context) string {
+				<-release
+				return addr
+			}),
+			"Slow": handler.New(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}),
+		}, &jrpc2.ServerOptions{
+			MethodTimeout: map[string]time.Duration{"Slow": 5 * time.Millisecond},
+		}).Start(sch)
+		return cch, nil
+	}
+	setDown := func(addr string, isDown bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		down[addr] = isDown
+		if s := servers[addr]; isDown && s != nil {
+			s.Stop()
+		}
+	}
+	waitHealthy := func(pool *jrpc2.Pool, want ...bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			var got []bool
+			for _, st := range pool.Status() {
+				got = append(got, st.Healthy)
+			}
+			if cmp.Equal(got, want) {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("Endpoint health: got %v, want %v", got, want)
+			}
+		}
+	}
+	who := func(pool *jrpc2.Pool, n int) map[string]int {
+		t.Helper()
+		count := make(map[string]int)
+		for i := 0; i < n; i++ {
+			var addr string
+			if err := pool.CallResult(ctx, "Who", nil, &addr); err != nil {
+				t.Fatalf("Call Who: unexpected error: %v", err)
+			}
+			count[addr]++
+		}
+		return count
+	}
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		var changes []string
+		var cmu sync.Mutex
+		pool := jrpc2.NewPool([]string{"a", "b", "c"}, dial, &jrpc2.PoolOptions{
+			ProbeInterval: 5 * time.Millisecond,
+			OnEndpointChange: func(addr string, healthy bool, err error) {
+				cmu.Lock()
+				defer cmu.Unlock()
+				changes = append(changes, fmt.Sprintf("%s:%v", addr, healthy))
+			},
+		})
+		defer pool.Close()
+		waitHealthy(pool, true, true, true)
+
+		// Calls are spread evenly across the endpoints.
+		if diff := cmp.Diff(map[string]int{"a": 2, "b": 2, "c": 2}, who(pool, 6)); diff != "" {
+			t.Errorf("Wrong call distribution (-want, +got):\n%s", diff)
+		}
+
+		// An endpoint that fails is ejected, and is not used.
+		setDown("b", true)
+		waitHealthy(pool, true, false, true)
+		if diff := cmp.Diff(map[string]int{"a": 2, "c": 2}, who(pool, 4)); diff != "" {
+			t.Errorf("Wrong call distribution (-want, +got):\n%s", diff)
+		}
+
+		// When the endpoint recovers, it is restored.
+		setDown("b", false)
+		waitHealthy(pool, true, true, true)
+		if got := who(pool, 6); got["b"] != 2 {
+			t.Errorf("Restored endpoint: got %d calls, want 2", got["b"])
+		}
+
+		if err := pool.Close(); err != nil {
+			t.Errorf("Close: unexpected error: %v", err)
+		}
+		if _, err := pool.Call(ctx, "Who", nil); err == nil {
+			t.Error("Call after Close: got nil, want error")
+		}
+		cmu.Lock()
+		defer cmu.Unlock()
+		want := []string{"b:true", "b:false", "b:true"}
+		var got []string
+		for _, c := range changes {
+			if strings.HasPrefix(c, "b:") {
+				got = append(got, c)
+			}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Wrong endpoint changes (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ServerTimeout", func(t *testing.T) {
+		pool := jrpc2.NewPool([]string{"t"}, dial, &jrpc2.PoolOptions{
+			ProbeInterval: time.Hour, // only the initial probe
+		})
+		defer pool.Close()
+		waitHealthy(pool, true)
+
+		// A timeout reported by the server is a reply, not a failure of the
+		// endpoint.
+		if _, err := pool.Call(ctx, "Slow", nil); err != context.DeadlineExceeded {
+			t.Errorf("Call Slow: got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if st := pool.Status(); !st[0].Healthy {
+			t.Error("Endpoint was ejected after a server timeout")
+		}
+		tctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if _, err := pool.Call(tctx, "Who", nil); err != nil {
+			t.Errorf("Call Who: unexpected error: %v", err)
+		}
+	})
+
+	t.Run("LeastPending", func(t *testing.T) {
+		pool := jrpc2.NewPool([]string{"x", "y"}, dial, &jrpc2.PoolOptions{
+			Balance:       jrpc2.LeastPending,
+			ProbeInterval: 5 * time.Millisecond,
+		})
+		defer pool.Close()
+		waitHealthy(pool, true, true)
+
+		// While a call to one endpoint is pending, calls go to the other.
+		blocked := make(chan string, 1)
+		go func() {
+			var addr string
+			pool.CallResult(ctx, "Block", nil, &addr)
+			blocked <- addr
+		}()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			st := pool.Status()
+			if st[0].Pending+st[1].Pending == 1 {
+				break
+			} else if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for a pending call")
+			}
+		}
+		free := "x"
+		if st := pool.Status(); st[0].Pending == 1 {
+			free = "y"
+		}
+		if diff := cmp.Diff(map[string]int{free: 3}, who(pool, 3)); diff != "" {
+			t.Errorf("Wrong call distribution (-want, +got):\n%s", diff)
+		}
+		close(release)
+		if got := <-blocked; got == free {
+			t.Errorf("Block: got endpoint %q, want the other", got)
+		}
+	})
+}
+
+// Verify that calls started asynchronously complete independently, and can be
+// cancelled.
+func TestClientStart(t *testing.T) {
+	release := make(chan struct{})
+	loc := server.NewLocal(handler.Map{
+		"Echo": handler.New(func(ctx context.Context, v []int) (int, error) {
+			<-release
+			return v[0], nil
+		}),
+		"Stall": handler.New(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	}, &server.LocalOptions{
+		Server: &jrpc2.ServerOptions{Concurrency: 16},
+	})
+	defer loc.Close()
+	c := loc.Client
+	ctx := context.Background()
+
+	var calls []*jrpc2.Pending
+	for i := 0; i < 10; i++ {
+		p, err := c.Start(ctx, "Echo", []int{i})
+		if err != nil {
+			t.Fatalf("Start(Echo, %d): unexpected error: %v", i, err)
+		}
+		calls = append(calls, p)
+	}
+	select {
+	case <-calls[0].Done():
+		t.Fatal("Call completed before it was released")
+	default:
+	}
+	close(release)
+	for i, p := range calls {
+		<-p.Done()
+		var got int
+		if err := p.WaitResult(&got); err != nil {
+			t.Errorf("Wait(%s): unexpected error: %v", p.ID(), err)
+		} else if got != i {
+			t.Errorf("Wait(%s): got %d, want %d", p.ID(), got, i)
+		}
+	}
+
+	// A hook added after the call is complete runs immediately.
+	var hooked bool
+	calls[0].OnDone(func(rsp *jrpc2.Response) { hooked = rsp.ResultString() == "0" })
+	if !hooked {
+		t.Error("OnDone after completion: hook did not run")
+	}
+
+	p, err := c.Start(ctx, "Stall", nil)
+	if err != nil {
+		t.Fatalf("Start(Stall): unexpected error: %v", err)
+	}
+	var cancelled *jrpc2.Error
+	p.OnDone(func(rsp *jrpc2.Response) { cancelled = rsp.Error() })
+	p.Cancel()
+	<-p.Done()
+	if rsp, err := p.Wait(); err != context.Canceled {
+		t.Errorf("Wait(Stall): got (%v, %v), want %v", rsp, err, context.Canceled)
+	}
+	if got := code.FromError(cancelled); got != code.Cancelled {
+		t.Errorf("OnDone(Stall): got code %v, want %v", got, code.Cancelled)
+	}
+	p.Cancel() // no effect once complete
+
+	if _, err := c.Start(ctx, "Echo", "not an array"); err == nil {
+		t.Error("Start with invalid params: got nil error, want error")
+	}
+}
@@ -31,6 +31,13 @@ type ServerOptions struct {
 	// the Notify and Callback methods of the server report errors if called.
 	AllowPush bool
 
+	// Instructs the server to notify the client when a callback is abandoned
+	// because its context ended before the client replied. The notification
+	// is an "rpc.cancel" request whose parameters are an array containing the
+	// ID of the abandoned call. This option has no effect unless AllowPush is
+	// also true.
+	CancelCallbacks bool
+
 	// Instructs the server to disable the built-in rpc.* handler methods.
 	//
 	// By default, a server reserves all rpc.* methods, even if the given
@@ -38,11 +45,92 @@ type ServerOptions struct {
 	// along to the given assigner.
 	DisableBuiltin bool
 
+	// If positive, the server applies this timeout to the context of each
+	// request, unless a different timeout is set for the method by
+	// MethodTimeout. A request whose timeout expires before its handler
+	// returns fails with code.DeadlineExceeded.
+	RequestTimeout time.Duration
+
+	// If set, overrides RequestTimeout for the specified methods. Keys have
+	// the same form as in MethodConcurrency. A value of zero or less disables
+	// the default timeout for the matching methods.
+	MethodTimeout map[string]time.Duration
+
+	// If positive, bounds the timeout applied to any request. This limit also
+	// applies to requests with no other timeout, and caps any deadline sent by
+	// the client as part of the request context (see DecodeContext).
+	MaxRequestTimeout time.Duration
+
+	// Instructs the server to execute the requests in each batch one at a
+	// time, in the order they appear in the batch, rather than concurrently.
+	// A client may also request ordered execution for a single batch, by
+	// sending a notification for the built-in "rpc.ordered" method as the
+	// first element of the batch.
+	OrderedBatches bool
+
+	// Instructs the server to stop executing an ordered batch when one of its
+	// requests fails. The requests following the failure are not executed,
+	// and report errors with code.Skipped. A client may request this for a
+	// single batch by passing {"stopOnError":true} as the parameters of the
+	// rpc.ordered marker.
+	StopBatchOnError bool
+
+	// Instructs the server to recover from panics in request handlers. If
+	// true, a panic in a handler is logged and reported to the client as an
+	// error with code.InternalError, instead of terminating the process.
+	RecoverPanics bool
+
+	// If true, and RecoverPanics is also true, the error reported for a panic
+	// in a handler includes the stack trace of the panic as error data. This is
+	// useful for debugging, but exposes server internals to the client.
+	PanicStackTrace bool
+
 	// Allows up to the specified number of goroutines to execute concurrently
 	// in request handlers. A value less than 1 uses runtime.NumCPU().  Note
 	// that this setting does not constrain order of issue.
 	Concurrency int
 
+	// If set, bounds the number of goroutines that may execute concurrently in
+	// handlers for the specified methods. Each key is either a method name, or
+	// a method name prefix followed by "*" (for example, "Index.*"). A request
+	// is governed by the longest key that matches its method name, if any.
+	// Values less than 1 are ignored. These limits apply in addition to the
+	// overall Concurrency limit.
+	MethodConcurrency map[string]int
+
+	// If nonzero, a request that would exceed its limit in MethodConcurrency
+	// fails immediately with an error having this code. Otherwise, such a
+	// request waits until its method has capacity.
+	MethodLimitCode code.Code
+
+	// If positive, the server holds at most this many requests that have been
+	// received from the client but have not yet completed.
+	MaxQueueRequests int
+
+	// If positive, the server holds at most this many bytes of encoded
+	// requests that have been received from the client but have not yet
+	// completed.
+	MaxQueueBytes int
+
+	// Controls what happens when a batch of requests would exceed the limits
+	// set by MaxQueueRequests or MaxQueueBytes. If false, the server stops
+	// reading from the channel until enough queued requests are dispatched.
+	// If true, the server immediately replies to each request in the batch
+	// with an error having code.Overloaded.
+	//
+	// Regardless of these limits, a batch is always accepted if the queue is
+	// empty. Note that while the server is blocked, it does not receive
+	// replies to server callbacks either (see AllowPush).
+	RejectOverload bool
+
+	// If positive, the server rejects a batch containing more than this many
+	// requests, replying with a single error having code.InvalidRequest.
+	//
+	// To limit the size of individual messages, use a channel whose framing
+	// has a size limit (see channel.Limit). The server replies to a message
+	// that exceeds that limit with an error having code.InvalidRequest.
+	MaxBatchSize int
+
 	// If set, this function is called with the method name and encoded request
 	// parameters received from the client, before they are delivered to the
 	// handler. Its return value replaces the context and argument values. This
@@ -55,6 +143,33 @@ type ServerOptions struct {
 	// the request fails with that error without invoking the handler.
 	CheckRequest func(ctx context.Context, req *Request) error
 
+	// If set, this function is called with the context and the client request
+	// to derive an ordering key for the request. Requests with the same
+	// non-empty key are executed one at a time, in the order they were
+	// received, while requests with different keys may run concurrently. An
+	// empty key imposes no ordering. This function is called while the server
+	// lock is held, so it should be quick and must not call back into the
+	// server.
+	OrderingKey func(ctx context.Context, req *Request) string
+
+	// If set, each request is passed through these interceptors before it
+	// reaches its handler. The interceptors are applied in order, so that the
+	// first interceptor is outermost and the last calls the handler directly.
+	// Interceptors are only invoked for requests that were assigned a handler.
+	Interceptors []Interceptor
+
+	// If not nil, the server exports the built-in rpc.discover method, which
+	// reports an OpenRPC document describing the methods of the server, with
+	// this value as the info section of the document. The methods are
+	// described by the server's assigner, if it implements MethodDescriber.
+	// This option has no effect if DisableBuiltin is true.
+	Discover *OpenRPCInfo
+
+	// If set, this function is called with the description of each method
+	// reported by rpc.discover, and may modify it, for example to add a
+	// summary, descriptions, or examples.
+	AnnotateMethod func(*MethodDoc)
+
 	// If set, use this value to record server metrics. All servers created
 	// from the same options will share the same metrics collector.  If none is
 	// set, an empty collector will be created for each new server.
@@ -77,6 +192,14 @@ func (s *ServerOptions) allowV1() bool      { return s != nil && s.AllowV1 }
 func (s *ServerOptions) allowPush() bool    { return s != nil && s.AllowPush }
 func (s *ServerOptions) allowBuiltin() bool { return s == nil || !s.DisableBuiltin }
 
+func (s *ServerOptions) cancelCallbacks() bool { return s != nil && s.CancelCallbacks }
+
+func (s *ServerOptions) orderedBatches() bool   { return s != nil && s.OrderedBatches }
+func (s *ServerOptions) stopBatchOnError() bool { return s != nil && s.StopBatchOnError }
+
+func (s *ServerOptions) recoverPanics() bool { return s != nil && s.RecoverPanics }
+func (s *ServerOptions) panicStack() bool    { return s != nil && s.PanicStackTrace }
+
 func (s *ServerOptions) concurrency() int64 {
 	if s == nil || s.Concurrency < 1 {
 		return int64(runtime.NumCPU())
@@ -84,6 +207,61 @@ func (s *ServerOptions) concurrency() int64 {
 	return int64(s.Concurrency)
 }
 
+func (s *ServerOptions) methodLimits() map[string]int {
+	if s == nil || len(s.MethodConcurrency) == 0 {
+		return nil
+	}
+	limits := make(map[string]int)
+	for key, n := range s.MethodConcurrency {
+		if n > 0 {
+			limits[key] = n
+		}
+	}
+	return limits
+}
+
+func (s *ServerOptions) methodLimitCode() code.Code {
+	if s == nil {
+		return 0
+	}
+	return s.MethodLimitCode
+}
+
+func (s *ServerOptions) queueLimits() (nreq, nbytes int, reject bool) {
+	if s == nil {
+		return 0, 0, false
+	}
+	return s.MaxQueueRequests, s.MaxQueueBytes, s.RejectOverload
+}
+
+func (s *ServerOptions) maxBatchSize() int {
+	if s == nil || s.MaxBatchSize < 0 {
+		return 0
+	}
+	return s.MaxBatchSize
+}
+
+func (s *ServerOptions) requestTimeout() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.RequestTimeout
+}
+
+func (s *ServerOptions) maxRequestTimeout() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.MaxRequestTimeout
+}
+
+func (s *ServerOptions) methodTimeouts() map[string]time.Duration {
+	if s == nil {
+		return nil
+	}
+	return s.MethodTimeout
+}
+
 func (s *ServerOptions) startTime() time.Time {
 	if s == nil {
 		return time.Time{}
@@ -111,6 +289,34 @@ func (s *ServerOptions) checkRequest() verifier {
 	return s.CheckRequest
 }
 
+func (s *ServerOptions) orderingKey() func(context.Context, *Request) string {
+	if s == nil {
+		return nil
+	}
+	return s.OrderingKey
+}
+
+func (s *ServerOptions) interceptors() []Interceptor {
+	if s == nil {
+		return nil
+	}
+	return s.Interceptors
+}
+
+func (s *ServerOptions) discoverInfo() *OpenRPCInfo {
+	if s == nil {
+		return nil
+	}
+	return s.Discover
+}
+
+func (s *ServerOptions) annotateMethod() func(*MethodDoc) {
+	if s == nil {
+		return nil
+	}
+	return s.AnnotateMethod
+}
+
 func (s *ServerOptions) metrics() *metrics.M {
 	if s == nil || s.Metrics == nil {
 		return metrics.New()
@@ -148,15 +354,45 @@ type ClientOptions struct {
 
 	// If set, this function is called if a notification is received from the
 	// server. If unset, server notifications are logged and discarded.  At
-	// most one invocation of the callback will be active at a time.
+	// most one invocation of the callback will be active at a time, unless
+	// CallbackConcurrency is positive.
 	// Server notifications are a non-standard extension of JSON-RPC.
 	OnNotify func(*Request)
 
 	// If set, this function is called if a request is received from the server.
 	// If unset, server requests are logged and discarded. At most one
-	// invocation of this callback will be active at a time.
+	// invocation of this callback will be active at a time, unless
+	// CallbackConcurrency is positive.
 	// Server callbacks are a non-standard extension of JSON-RPC.
 	OnCallback func(context.Context, *Request) (interface{}, error)
+
+	// If set, requests and notifications received from the server are
+	// dispatched to the handlers this assigner associates with their method
+	// names, instead of to OnCallback and OnNotify. A callback for a method
+	// with no handler is answered with code.MethodNotFound, and a notification
+	// for such a method is logged and discarded.
+	Callbacks Assigner
+
+	// If positive, requests and notifications from the server are handled on
+	// separate goroutines, with at most this many active at a time. This
+	// permits a handler to call back into the server using the client. When
+	// this is set, an rpc.cancel notification from the server cancels the
+	// context of the callback it names.
+	//
+	// By default, server requests are handled one at a time by the goroutine
+	// that reads responses from the server, so that no responses are received
+	// while a handler is active.
+	CallbackConcurrency int
+
+	// If set, calls made by the Call and CallResult methods that fail as
+	// described by this policy are retried. By default, calls are not retried.
+	Retry *RetryPolicy
+
+	// If set, each call, batch, and notification sent by the client,
+	// including calls made by Start, is passed through these interceptors.
+	// The interceptors are applied in order, so that the first interceptor is
+	// outermost and the last sends the messages.
+	Interceptors []ClientInterceptor
 }
 
 func (c *ClientOptions) logger() logger {
@@ -181,21 +417,61 @@ func (c *ClientOptions) encodeContext() encoder {
 	return c.EncodeContext
 }
 
-func (c *ClientOptions) handleNotification() func(*jmessage) {
-	if c == nil || c.OnNotify == nil {
+func (c *ClientOptions) retryPolicy() *RetryPolicy {
+	if c == nil || c.Retry == nil || c.Retry.MaxAttempts < 2 {
+		return nil
+	}
+	return c.Retry
+}
+
+func (c *ClientOptions) interceptors() []ClientInterceptor {
+	if c == nil {
 		return nil
 	}
-	h := c.OnNotify
-	return func(req *jmessage) { h(&Request{method: req.M, params: req.P}) }
+	return c.Interceptors
+}
+
+func (c *ClientOptions) callbackConcurrency() int {
+	if c == nil || c.CallbackConcurrency < 0 {
+		return 0
+	}
+	return c.CallbackConcurrency
+}
+
+func (c *ClientOptions) handleNotification() func(context.Context, *jmessage) {
+	if c == nil {
+		return nil
+	} else if a := c.Callbacks; a != nil {
+		return func(ctx context.Context, req *jmessage) {
+			r := &Request{method: req.M, params: req.P}
+			if h := a.Assign(ctx, req.M); h != nil {
+				h.Handle(ctx, r)
+			}
+		}
+	} else if h := c.OnNotify; h != nil {
+		return func(_ context.Context, req *jmessage) { h(&Request{method: req.M, params: req.P}) }
+	}
+	return nil
 }
 
-func (c *ClientOptions) handleCallback() func(*jmessage) ([]byte, error) {
-	if c == nil || c.OnCallback == nil {
+func (c *ClientOptions) handleCallback() func(context.Context, *jmessage) ([]byte, error) {
+	var cb func(context.Context, *Request) (interface{}, error)
+	if c == nil {
+		return nil
+	} else if a := c.Callbacks; a != nil {
+		cb = func(ctx context.Context, req *Request) (interface{}, error) {
+			if h := a.Assign(ctx, req.method); h != nil {
+				return h.Handle(ctx, req)
+			}
+			return nil, Errorf(code.MethodNotFound, "no such method %q", req.method)
+		}
+	} else if c.OnCallback != nil {
+		cb = c.OnCallback
+	} else {
 		return nil
 	}
-	cb := c.OnCallback
-	return func(req *jmessage) ([]byte, error) {
-		ctx, cancel := context.WithCancel(context.Background())
+	return func(ctx context.Context, req *jmessage) ([]byte, error) {
+		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
 		rsp := &jmessage{V: Version, ID: req.ID}
@@ -232,6 +508,153 @@ type RPCLogger interface {
 	LogResponse(ctx context.Context, rsp *Response)
 }
 
+// RedialOptions control the behaviour of a RedialClient. A nil *RedialOptions
+// provides sensible defaults.
+type RedialOptions struct {
+	// Options for each client constructed for a new connection.
+	Client *ClientOptions
+
+	// The delay before dialing again after a failed attempt or a lost
+	// connection. Successive failures double the delay, up to MaxBackoff. The
+	// delay is reset when a connection has lasted at least MaxBackoff. The
+	// default is 100ms.
+	MinBackoff time.Duration
+
+	// The maximum delay between attempts to dial. The default is 30s.
+	MaxBackoff time.Duration
+
+	// If true, a call or notification that fails because the connection was
+	// lost is sent again when a new connection is established, until it
+	// succeeds or its context ends. This may cause a call to be executed more
+	// than once, so it should be enabled only if the server's methods are
+	// idempotent. By default, such calls fail with ErrConnLost.
+	ReplayCalls bool
+
+	// If set, this function is called each time the connection state
+	// changes. If the change is due to an error, such as a failure to dial or
+	// a broken connection, that error is also reported. The calls are made
+	// sequentially, in the order the changes occurred.
+	OnStateChange func(ConnState, error)
+}
+
+func (o *RedialOptions) clientOptions() *ClientOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Client
+}
+
+func (o *RedialOptions) minBackoff() time.Duration {
+	if o == nil || o.MinBackoff <= 0 {
+		return 100 * time.Millisecond
+	}
+	return o.MinBackoff
+}
+
+func (o *RedialOptions) maxBackoff() time.Duration {
+	max := 30 * time.Second
+	if o != nil && o.MaxBackoff > 0 {
+		max = o.MaxBackoff
+	}
+	if min := o.minBackoff(); max < min {
+		return min
+	}
+	return max
+}
+
+func (o *RedialOptions) replayCalls() bool { return o != nil && o.ReplayCalls }
+
+func (o *RedialOptions) onStateChange() func(ConnState, error) {
+	if o == nil || o.OnStateChange == nil {
+		return func(ConnState, error) {}
+	}
+	return o.OnStateChange
+}
+
+// PoolOptions control the behaviour of a Pool. A nil *PoolOptions provides
+// sensible defaults.
+type PoolOptions struct {
+	// Options for each client constructed for a connection to an endpoint.
+	Client *ClientOptions
+
+	// The strategy for choosing an endpoint for each call. The default is
+	// RoundRobin.
+	Balance Balance
+
+	// The number of consecutive calls to an endpoint that must fail with
+	// transport errors before it is ejected from the pool. An endpoint whose
+	// connection has failed is ejected immediately. The default is 1.
+	MaxFailures int
+
+	// If set, this function is called to check the health of an endpoint via
+	// its client. A non-nil error means the endpoint is unhealthy. By default,
+	// the probe calls the built-in rpc.serverInfo method, and any reply from
+	// the server indicates the endpoint is healthy.
+	Probe func(context.Context, *Client) error
+
+	// The interval between health probes of the endpoints. The default is 5s.
+	ProbeInterval time.Duration
+
+	// The time allowed for dialing and probing an endpoint. The default is
+	// the probe interval.
+	ProbeTimeout time.Duration
+
+	// If set, this function is called each time an endpoint becomes healthy
+	// or is ejected from the pool, with the address of the endpoint and
+	// whether it is now healthy. If the change is due to an error, that error
+	// is also reported. Calls for different endpoints may be concurrent.
+	OnEndpointChange func(addr string, healthy bool, err error)
+}
+
+func (o *PoolOptions) clientOptions() *ClientOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Client
+}
+
+func (o *PoolOptions) balance() Balance {
+	if o == nil {
+		return RoundRobin
+	}
+	return o.Balance
+}
+
+func (o *PoolOptions) maxFailures() int {
+	if o == nil || o.MaxFailures <= 0 {
+		return 1
+	}
+	return o.MaxFailures
+}
+
+func (o *PoolOptions) probe() func(context.Context, *Client) error {
+	if o == nil || o.Probe == nil {
+		return defaultProbe
+	}
+	return o.Probe
+}
+
+func (o *PoolOptions) probeInterval() time.Duration {
+	if o == nil || o.ProbeInterval <= 0 {
+		return 5 * time.Second
+	}
+	return o.ProbeInterval
+}
+
+func (o *PoolOptions) probeTimeout() time.Duration {
+	if o == nil || o.ProbeTimeout <= 0 {
+		return o.probeInterval()
+	}
+	return o.ProbeTimeout
+}
+
+func (o *PoolOptions) onChange() func(string, bool, error) {
+	if o == nil || o.OnEndpointChange == nil {
+		return func(string, bool, error) {}
+	}
+	return o.OnEndpointChange
+}
+
 type nullRPCLogger struct{}
 
 func (nullRPCLogger) LogRequest(context.Context, *Request)   {}
@@ -0,0 +1,345 @@
+package jrpc2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/channel"
+)
+
+// A Balance is a strategy for choosing among the healthy endpoints of a Pool.
+type Balance int
+
+// The strategies supported by a Pool.
+const (
+	RoundRobin   Balance = iota // cycle through the endpoints in order
+	LeastPending                // choose the endpoint with the fewest pending calls
+)
+
+// A Pool is a JSON-RPC client that distributes calls among connections to
+// several servers, such as the replicas of a service.
+//
+// The pool dials each of its addresses, and sends each call to one of the
+// endpoints that are connected and healthy, as chosen by the Balance option.
+// An endpoint is ejected from the pool when calls to it fail with transport
+// errors, and is probed periodically in the background, redialing it if
+// necessary, until it is healthy again.
+//
+// Calls issued when no endpoint is healthy wait until one is available or
+// their context ends.
+type Pool struct {
+	dial     func(context.Context, string) (channel.Channel, error)
+	copts    *ClientOptions
+	balance  Balance
+	maxFail  int
+	probe    func(context.Context, *Client) error
+	interval time.Duration
+	timeout  time.Duration
+	onChange func(string, bool, error)
+
+	ctx  context.Context    // governs probes; ends when the pool is closed
+	stop context.CancelFunc // cancels ctx
+	done chan struct{}      // closed when the probe goroutine exits
+
+	mu     sync.Mutex
+	eps    []*endpoint
+	next   int           // the next endpoint for round-robin selection
+	wake   chan struct{} // closed when an endpoint becomes healthy
+	closed bool
+}
+
+// An endpoint is a connection to one of the servers in a pool.
+type endpoint struct {
+	addr     string
+	cli      *Client // the current client, or nil if not connected
+	healthy  bool    // whether the endpoint may be chosen
+	pending  int     // calls in progress
+	failures int     // consecutive failed calls
+}
+
+// EndpointStatus describes the state of an endpoint in a Pool.
+type EndpointStatus struct {
+	Addr    string // the address of the endpoint
+	Healthy bool   // whether calls are sent to the endpoint
+	Pending int    // the number of calls in progress
+}
+
+// NewPool returns a new pool that communicates with servers at the given
+// addresses, via channels returned by calling dial with each address. The
+// pool begins dialing the addresses immediately in the background.
+func NewPool(addrs []string, dial func(ctx context.Context, addr string) (channel.Channel, error), opts *PoolOptions) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		dial:     dial,
+		copts:    opts.clientOptions(),
+		balance:  opts.balance(),
+		maxFail:  opts.maxFailures(),
+		probe:    opts.probe(),
+		interval: opts.probeInterval(),
+		timeout:  opts.probeTimeout(),
+		onChange: opts.onChange(),
+		ctx:      ctx,
+		stop:     cancel,
+		done:     make(chan struct{}),
+		wake:     make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		p.eps = append(p.eps, &endpoint{addr: addr})
+	}
+	go p.run()
+	return p
+}
+
+// Status reports the current state of each endpoint of p, in the order of
+// their addresses.
+func (p *Pool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]EndpointStatus, len(p.eps))
+	for i, e := range p.eps {
+		out[i] = EndpointStatus{Addr: e.addr, Healthy: e.healthy, Pending: e.pending}
+	}
+	return out
+}
+
+// Call sends a single request to one of the healthy endpoints of p, and blocks
+// until the response returns, as (*Client).Call does.
+func (p *Pool) Call(ctx context.Context, method string, params interface{}) (*Response, error) {
+	var rsp *Response
+	err := p.do(ctx, func(cli *Client) (err error) {
+		rsp, err = cli.callRetry(ctx, method, params)
+		return
+	})
+	return callResult(rsp, err)
+}
+
+// CallResult invokes Call with the given method and params. If it succeeds,
+// the result is decoded into result, as (*Client).CallResult does.
+func (p *Pool) CallResult(ctx context.Context, method string, params, result interface{}) error {
+	rsp, err := p.Call(ctx, method, params)
+	if err != nil {
+		return err
+	}
+	return rsp.UnmarshalResult(result)
+}
+
+// Batch sends a batch of requests to one of the healthy endpoints of p, and
+// blocks until all the responses return, as (*Client).Batch does.
+func (p *Pool) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
+	var rsps []*Response
+	err := p.do(ctx, func(cli *Client) (err error) {
+		rsps, err = cli.batch(ctx, specs)
+		return
+	})
+	if err != nil {
+		return nil, unwrapSend(err)
+	}
+	return rsps, nil
+}
+
+// Notify sends a notification to one of the healthy endpoints of p, as
+// (*Client).Notify does.
+func (p *Pool) Notify(ctx context.Context, method string, params interface{}) error {
+	return unwrapSend(p.do(ctx, func(cli *Client) error {
+		_, err := cli.batch(ctx, []Spec{{Method: method, Params: params, Notify: true}})
+		return err
+	}))
+}
+
+// Close shuts down the pool, closing the connections to all its endpoints and
+// abandoning any pending in-flight requests.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.wake)
+	p.mu.Unlock()
+
+	p.stop()
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.eps {
+		if e.cli != nil {
+			e.cli.Close()
+			e.cli = nil
+		}
+		e.healthy = false
+	}
+	return nil
+}
+
+// do calls f with the client for an endpoint chosen by p, and records the
+// outcome. The function f must report errors as (*Client).call does, so that
+// errors from the server, including timeouts, are not mistaken for failures
+// of the connection.
+func (p *Pool) do(ctx context.Context, f func(*Client) error) error {
+	e, cli, err := p.choose(ctx)
+	if err != nil {
+		return err
+	}
+	err = f(cli)
+	failed := cli.stopped() || isSendError(err)
+
+	p.mu.Lock()
+	e.pending--
+	if !failed {
+		if err == nil {
+			e.failures = 0 // the server replied
+		}
+		p.mu.Unlock()
+		return err
+	}
+	e.failures++
+	eject := e.healthy && e.cli == cli && (e.failures >= p.maxFail || cli.stopped())
+	if eject {
+		e.healthy = false
+	}
+	p.mu.Unlock()
+	if eject {
+		p.onChange(e.addr, false, err)
+	}
+	return err
+}
+
+// choose selects a healthy endpoint, waiting for one if necessary, and counts
+// a pending call for it.
+func (p *Pool) choose(ctx context.Context) (*endpoint, *Client, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, nil, errClientStopped
+		}
+		if e := p.pick(); e != nil {
+			e.pending++
+			cli := e.cli
+			p.mu.Unlock()
+			return e, cli, nil
+		}
+		wake := p.wake
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// pick returns a healthy endpoint according to the balance strategy, or nil
+// if there are none. The caller must hold p.mu.
+func (p *Pool) pick() *endpoint {
+	var best *endpoint
+	for i := range p.eps {
+		e := p.eps[(p.next+i)%len(p.eps)]
+		if !e.healthy {
+			continue
+		} else if p.balance == RoundRobin {
+			p.next = (p.next + i + 1) % len(p.eps)
+			return e
+		} else if best == nil || e.pending < best.pending {
+			best = e
+		}
+	}
+	if best != nil {
+		p.next = (p.next + 1) % len(p.eps) // break ties in rotation
+	}
+	return best
+}
+
+// run probes the endpoints of p periodically until p is closed.
+func (p *Pool) run() {
+	defer close(p.done)
+	for {
+		var wg sync.WaitGroup
+		for _, e := range p.eps {
+			wg.Add(1)
+			go func(e *endpoint) {
+				defer wg.Done()
+				p.check(e)
+			}(e)
+		}
+		wg.Wait()
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// check probes e, dialing it first if it is not connected, and updates its
+// health accordingly.
+func (p *Pool) check(e *endpoint) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	defer cancel()
+
+	p.mu.Lock()
+	cli := e.cli
+	p.mu.Unlock()
+
+	var err error
+	dialed := false
+	if cli == nil || cli.stopped() {
+		ch, derr := p.dial(ctx, e.addr)
+		if derr != nil {
+			err = derr
+		} else {
+			cli, dialed = NewClient(ch, p.copts), true
+		}
+	}
+	if err == nil {
+		err = p.probe(ctx, cli)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		if dialed {
+			cli.Close() // the pool was closed while probing
+		}
+		return
+	}
+	var old *Client
+	if dialed {
+		old, e.cli = e.cli, cli
+	}
+	wasHealthy, healthy := e.healthy, err == nil
+	e.healthy = healthy
+	if healthy {
+		e.failures = 0
+		if !wasHealthy {
+			close(p.wake)
+			p.wake = make(chan struct{})
+		}
+	}
+	p.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	if healthy != wasHealthy {
+		p.onChange(e.addr, healthy, err)
+	}
+}
+
+// defaultProbe checks the health of a server by calling the built-in
+// rpc.serverInfo method. Any reply from the server, even an error, indicates
+// that the server is reachable.
+func defaultProbe(ctx context.Context, cli *Client) error {
+	if _, err := cli.call(ctx, rpcServerInfo, nil); err != nil {
+		return unwrapSend(err)
+	} else if err := ctx.Err(); err != nil {
+		return err // the server did not reply in time
+	} else if cli.stopped() {
+		return ErrConnLost
+	}
+	return nil
+}
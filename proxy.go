@@ -0,0 +1,151 @@
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	ctxType       = reflect.TypeOf((*context.Context)(nil)).Elem() // type context.Context
+	errType       = reflect.TypeOf((*error)(nil)).Elem()           // type error
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()  // type json.Marshaler
+)
+
+// Bind populates the function-valued fields of the struct pointed to by v with
+// functions that call the corresponding methods of the server via c. This is
+// the client-side counterpart of handler.NewService. For example:
+//
+//    var math struct {
+//       Add func(context.Context, ...int) (int, error)
+//       Div func(context.Context, DivParams) (float64, error)
+//       Log func(context.Context, []string) error `jrpc2:"Logger.Write"`
+//    }
+//    if err := cli.Bind("Math", &math); err != nil {
+//       log.Fatal(err)
+//    }
+//    sum, err := math.Add(ctx, 1, 2, 3) // calls "Math.Add" with [1,2,3]
+//
+// Each exported field of function type must have one of the signatures:
+//
+//    func(context.Context) error
+//    func(context.Context) (Y, error)
+//    func(context.Context, X) error
+//    func(context.Context, X) (Y, error)
+//    func(context.Context, ...X) (Y, error)
+//
+// where the parameters X are sent as the parameters of the request, and the
+// result is decoded into a value of type Y. A function that returns only error
+// discards the result. Since the parameters of a request must be an array or
+// an object, X must be a struct, map, slice, or array type (or a pointer to
+// one), an interface, or a type that implements json.Marshaler.
+//
+// By default, the method called by a field is the name of the field, prefixed
+// by service and a period ("Service.Field") if service != "". If the field has
+// a tag of the form jrpc2:"name", the method is the tag value instead, without
+// a service prefix. Fields tagged jrpc2:"-" and fields of other types are not
+// modified.
+//
+// Bind reports an error without modifying v if v is not a non-nil pointer to
+// a struct, or if any of the function fields has an unsupported signature.
+func (c *Client) Bind(service string, v interface{}) error {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return errors.New("value is not a pointer to a struct")
+	}
+	sv := pv.Elem()
+	st := sv.Type()
+
+	fns := make(map[int]reflect.Value)
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		tag := f.Tag.Get("jrpc2")
+		if f.PkgPath != "" || f.Type.Kind() != reflect.Func || tag == "-" {
+			continue
+		}
+		if err := checkProxyType(f.Type); err != nil {
+			return fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		method := tag
+		if method == "" {
+			method = f.Name
+			if service != "" {
+				method = service + "." + method
+			}
+		}
+		fns[i] = c.newProxy(method, f.Type)
+	}
+	for i, fn := range fns {
+		sv.Field(i).Set(fn)
+	}
+	return nil
+}
+
+// checkProxyType reports whether ft is a supported signature for Bind.
+func checkProxyType(ft reflect.Type) error {
+	if np := ft.NumIn(); np == 0 || np > 2 {
+		return errors.New("wrong number of parameters")
+	} else if ft.In(0) != ctxType {
+		return errors.New("first parameter is not context.Context")
+	} else if no := ft.NumOut(); no == 0 || no > 2 {
+		return errors.New("wrong number of results")
+	} else if ft.Out(no-1) != errType {
+		return errors.New("last result is not of type error")
+	} else if ft.NumIn() == 2 && !ft.IsVariadic() && !isCompositeType(ft.In(1)) {
+		return fmt.Errorf("parameter type %v does not encode as an array or object", ft.In(1))
+	}
+	return nil
+}
+
+// isCompositeType reports whether values of type t may encode as a JSON array
+// or object. A type with its own JSON encoding is assumed to do so.
+func isCompositeType(t reflect.Type) bool {
+	for {
+		if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+			return true
+		} else if t.Kind() != reflect.Ptr {
+			break
+		}
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Array, reflect.Interface:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8 // []byte encodes as a string
+	}
+	return false
+}
+
+// newProxy returns a function of type ft that calls method via c.
+func (c *Client) newProxy(method string, ft reflect.Type) reflect.Value {
+	return reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		ctx, _ := args[0].Interface().(context.Context)
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var params interface{}
+		if len(args) == 2 {
+			params = args[1].Interface()
+		}
+		if ft.NumOut() == 1 {
+			_, err := c.Call(ctx, method, params)
+			return []reflect.Value{errorValue(err)}
+		}
+		out := reflect.New(ft.Out(0))
+		if err := c.CallResult(ctx, method, params, out.Interface()); err != nil {
+			return []reflect.Value{reflect.Zero(ft.Out(0)), errorValue(err)}
+		}
+		return []reflect.Value{out.Elem(), errorValue(nil)}
+	})
+}
+
+// errorValue returns err as a reflect.Value of type error.
+func errorValue(err error) reflect.Value {
+	if err == nil {
+		return reflect.Zero(errType)
+	}
+	return reflect.ValueOf(&err).Elem()
+}
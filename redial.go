@@ -0,0 +1,285 @@
+package jrpc2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/channel"
+)
+
+// A ConnState describes the state of the connection of a RedialClient.
+type ConnState int
+
+// The possible states of a RedialClient connection.
+const (
+	Connecting   ConnState = iota // dialing the server
+	Connected                     // connected to the server
+	Disconnected                  // the connection failed or was lost
+	Closed                        // the client was closed
+)
+
+var connStateNames = [...]string{"connecting", "connected", "disconnected", "closed"}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateNames) {
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+	return connStateNames[s]
+}
+
+// A RedialClient is a JSON-RPC client that maintains a connection to a server,
+// dialing the server again when the connection is lost.
+//
+// Calls issued while the client is not connected wait until a connection is
+// established or their context ends. Calls that are in flight when the
+// connection is lost fail with an error that wraps ErrConnLost, unless the
+// ReplayCalls option is set, in which case they are issued again on the next
+// connection.
+type RedialClient struct {
+	dial    func(context.Context) (channel.Channel, error)
+	copts   *ClientOptions
+	minWait time.Duration
+	maxWait time.Duration
+	replay  bool
+	onState func(ConnState, error)
+
+	ctx  context.Context    // governs dialing; ends when the client is closed
+	stop context.CancelFunc // cancels ctx
+	done chan struct{}      // closed when the dialing goroutine exits
+
+	mu    sync.Mutex
+	state ConnState
+	cli   *Client       // the current client, or nil if not connected
+	wake  chan struct{} // closed when the state changes
+}
+
+// NewRedialClient returns a new client that communicates with a server via
+// the channels returned by dial. The client begins dialing immediately in the
+// background. The context passed to dial ends when the client is closed.
+func NewRedialClient(dial func(context.Context) (channel.Channel, error), opts *RedialOptions) *RedialClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RedialClient{
+		dial:    dial,
+		copts:   opts.clientOptions(),
+		minWait: opts.minBackoff(),
+		maxWait: opts.maxBackoff(),
+		replay:  opts.replayCalls(),
+		onState: opts.onStateChange(),
+		ctx:     ctx,
+		stop:    cancel,
+		done:    make(chan struct{}),
+		state:   Connecting,
+		wake:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// State reports the current connection state of r.
+func (r *RedialClient) State() ConnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// Call initiates a single request on the current connection, and blocks until
+// the response returns, as (*Client).Call does.
+func (r *RedialClient) Call(ctx context.Context, method string, params interface{}) (*Response, error) {
+	var rsp *Response
+	err := r.do(ctx, func(cli *Client) (err error) {
+		rsp, err = cli.Call(ctx, method, params)
+		return
+	})
+	return rsp, err
+}
+
+// CallResult invokes Call with the given method and params. If it succeeds,
+// the result is decoded into result, as (*Client).CallResult does.
+func (r *RedialClient) CallResult(ctx context.Context, method string, params, result interface{}) error {
+	rsp, err := r.Call(ctx, method, params)
+	if err != nil {
+		return err
+	}
+	return rsp.UnmarshalResult(result)
+}
+
+// Batch initiates a batch of concurrent requests on the current connection,
+// and blocks until all the responses return, as (*Client).Batch does. Only a
+// batch that could not be sent is replayed; if the connection is lost after
+// the batch is sent, the responses not yet received report errors.
+func (r *RedialClient) Batch(ctx context.Context, specs []Spec) ([]*Response, error) {
+	var rsps []*Response
+	err := r.do(ctx, func(cli *Client) (err error) {
+		rsps, err = cli.Batch(ctx, specs)
+		return
+	})
+	return rsps, err
+}
+
+// Notify transmits a notification on the current connection, as
+// (*Client).Notify does.
+func (r *RedialClient) Notify(ctx context.Context, method string, params interface{}) error {
+	return r.do(ctx, func(cli *Client) error {
+		return cli.Notify(ctx, method, params)
+	})
+}
+
+// Close shuts down the client, closing the current connection if there is
+// one, and abandoning any pending in-flight requests.
+func (r *RedialClient) Close() error {
+	r.stop()
+	<-r.done
+	r.mu.Lock()
+	if r.state == Closed {
+		r.mu.Unlock()
+		return nil
+	}
+	cli := r.cli
+	r.setStateLocked(Closed, nil)
+	r.mu.Unlock()
+	r.onState(Closed, nil)
+	if cli != nil {
+		return cli.Close()
+	}
+	return nil
+}
+
+// do calls f with the current client until it succeeds, fails for a reason
+// other than loss of the connection, or ctx ends.
+func (r *RedialClient) do(ctx context.Context, f func(*Client) error) error {
+	for {
+		cli, err := r.client(ctx)
+		if err != nil {
+			return err
+		}
+		err = f(cli)
+		if err == nil || ctx.Err() != nil || !cli.stopped() {
+			return err
+		} else if !r.replay {
+			return fmt.Errorf("%w: %v", ErrConnLost, err)
+		}
+		// Wait for the dialer to notice the lost connection, then retry.
+		r.waitChange(ctx, cli)
+	}
+}
+
+// client returns the current client, waiting for a connection if necessary.
+func (r *RedialClient) client(ctx context.Context) (*Client, error) {
+	for {
+		r.mu.Lock()
+		cli, state, wake := r.cli, r.state, r.wake
+		r.mu.Unlock()
+		if state == Closed || r.ctx.Err() != nil {
+			return nil, errClientStopped
+		} else if cli != nil && !cli.stopped() {
+			return cli, nil
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitChange blocks until the current client is no longer cli, r is closed,
+// or ctx ends.
+func (r *RedialClient) waitChange(ctx context.Context, cli *Client) {
+	for {
+		r.mu.Lock()
+		cur, wake := r.cli, r.wake
+		r.mu.Unlock()
+		if cur != cli {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// run dials the server and maintains the connection until r is closed.
+func (r *RedialClient) run() {
+	defer close(r.done)
+	wait := r.minWait
+	for {
+		r.setState(Connecting, nil, nil)
+		ch, err := r.dial(r.ctx)
+		if r.ctx.Err() != nil {
+			if err == nil {
+				ch.Close()
+			}
+			return
+		} else if err != nil {
+			r.setState(Disconnected, nil, err)
+		} else {
+			cli := NewClient(ch, r.copts)
+			r.setState(Connected, cli, nil)
+			start := time.Now()
+			select {
+			case <-cli.done:
+			case <-r.ctx.Done():
+				return // Close will shut down cli
+			}
+			r.setState(Disconnected, nil, cli.lastError())
+
+			// Only a connection that was stable resets the delay, so that a
+			// server that accepts and then drops connections is not redialed
+			// in a tight loop.
+			if time.Since(start) >= r.maxWait {
+				wait = r.minWait
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-r.ctx.Done():
+			return
+		}
+		if wait *= 2; wait > r.maxWait {
+			wait = r.maxWait
+		}
+	}
+}
+
+// setState records a new state and client for r, and reports the change.
+func (r *RedialClient) setState(state ConnState, cli *Client, err error) {
+	r.mu.Lock()
+	r.setStateLocked(state, cli)
+	r.mu.Unlock()
+	r.onState(state, err)
+}
+
+// setStateLocked records a new state and client for r, and wakes any callers
+// waiting for a state change. The caller must hold r.mu.
+func (r *RedialClient) setStateLocked(state ConnState, cli *Client) {
+	r.state = state
+	r.cli = cli
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// stopped reports whether c has stopped, because its channel failed or it was
+// closed.
+func (c *Client) stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ch == nil
+}
+
+// lastError reports the error that stopped c, or nil if that error was an
+// orderly shutdown of the channel.
+func (c *Client) lastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if isUninteresting(c.err) {
+		return nil
+	}
+	return c.err
+}
@@ -0,0 +1,140 @@
+package jrpc2
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/creachadair/jrpc2/code"
+)
+
+// A RetryPolicy describes when and how a client issues a call again after it
+// fails. A call is retried if it fails with one of the specified error codes,
+// or with a transport error if Transport is set, until it succeeds, the
+// maximum number of attempts is reached, or its context ends.
+//
+// Each attempt is sent as a new request. The delay before each retry grows
+// exponentially from MinBackoff to MaxBackoff, randomized by Jitter. A retry
+// is not attempted if the delay would exceed the deadline of the context.
+type RetryPolicy struct {
+	// The maximum number of attempts for each call, including the first.
+	// If this is less than 2, calls are not retried.
+	MaxAttempts int
+
+	// Calls that fail with errors having these codes are retried, for example
+	// code.SystemError or a custom "overloaded" code.
+	Codes []code.Code
+
+	// If true, calls that fail because the request could not be sent on the
+	// channel are retried. Errors in preparing a request, such as a failure to
+	// marshal its parameters, are never retried. Retries are not attempted
+	// once the client has stopped; to issue calls again on a new connection,
+	// see the ReplayCalls option of RedialOptions.
+	Transport bool
+
+	// The delay before the first retry. Successive retries double the delay,
+	// up to MaxBackoff. The default is 50ms.
+	MinBackoff time.Duration
+
+	// The maximum delay between retries. The default is 5s.
+	MaxBackoff time.Duration
+
+	// The fraction of each delay, between 0 and 1, that is randomized. For
+	// example, if Jitter is 0.5 the actual delay is between half and all of
+	// the computed delay. Values outside this range are clamped.
+	Jitter float64
+
+	// If set, only calls to methods for which this function reports true are
+	// retried. A call should be retried only if it is safe to execute its
+	// method more than once. If nil, calls to all methods may be retried.
+	Idempotent func(method string) bool
+}
+
+func (p *RetryPolicy) minBackoff() time.Duration {
+	if p.MinBackoff <= 0 {
+		return 50 * time.Millisecond
+	}
+	return p.MinBackoff
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	if min := p.minBackoff(); max < min {
+		return min
+	}
+	return max
+}
+
+// delay returns the delay before retry number n, counting from 1.
+func (p *RetryPolicy) delay(n int) time.Duration {
+	d, max := p.minBackoff(), p.maxBackoff()
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if j := p.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(j * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// retryable reports whether a call to method that resulted in rsp and err,
+// as reported by (*Client).call, may be retried.
+func (p *RetryPolicy) retryable(method string, rsp *Response, err error) bool {
+	if p.Idempotent != nil && !p.Idempotent(method) {
+		return false
+	} else if err != nil {
+		return p.Transport && isSendError(err)
+	}
+	e := rsp.Error()
+	if e == nil {
+		return false
+	}
+	for _, c := range p.Codes {
+		if e.code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// call invokes the call function for method until it succeeds, it fails in a
+// way that is not retryable according to p, or ctx ends. The stopped function
+// reports whether the client has stopped. The call function reports its
+// results as (*Client).call does, with errors from the server recorded in the
+// response, so that they can be examined before their codes are mapped to
+// context errors.
+func (p *RetryPolicy) call(ctx context.Context, method string, log func(string, ...interface{}),
+	stopped func() bool, call func() (*Response, error)) (*Response, error) {
+	for n := 1; ; n++ {
+		rsp, err := call()
+		if n >= p.MaxAttempts || ctx.Err() != nil || stopped() || !p.retryable(method, rsp, err) {
+			return rsp, err
+		}
+
+		d := p.delay(n)
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < d {
+			return rsp, err // no time for another attempt
+		}
+		cause := err
+		if cause == nil {
+			cause = rsp.Error()
+		}
+		log("Retrying call to %q in %v (attempt %d): %v", method, d, n+1, cause)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return rsp, err
+		case <-t.C:
+		}
+	}
+}
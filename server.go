@@ -4,7 +4,10 @@ import (
 	"container/list"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,14 +30,44 @@ type Server struct {
 	sem     *semaphore.Weighted // bounds concurrent execution (default 1)
 	allow1  bool                // allow v1 requests with no version marker
 	allowP  bool                // allow server notifications to the client
+	cancelP bool                // send rpc.cancel for abandoned callbacks
 	log     logger              // write debug logs here
 	rpcLog  RPCLogger           // log RPC requests and responses here
 	dectx   decoder             // decode context from request
 	ckreq   verifier            // request checking hook
+	icept   []Interceptor       // interceptors wrapping each handler
 	expctx  bool                // whether to expect request context
 	metrics *metrics.M          // metrics collected during execution
 	start   time.Time           // when Start was called
 	builtin bool                // whether built-in rpc.* methods are enabled
+	recov   bool                // whether to recover panics in handlers
+	pstack  bool                // whether to report stack traces for panics
+
+	// Per-method concurrency limits (see ServerOptions.MethodConcurrency).
+	limits  map[string]int                 // limit values, by method key
+	limsem  map[string]*semaphore.Weighted // semaphores, by method key
+	limcode code.Code                      // if nonzero, reject requests over the limit
+
+	// Request timeouts (see ServerOptions.RequestTimeout).
+	deftime time.Duration            // default timeout (0 means none)
+	maxtime time.Duration            // maximum timeout (0 means none)
+	mtimes  map[string]time.Duration // per-method timeouts, by method key
+
+	// Batch execution order (see ServerOptions.OrderedBatches).
+	ordered bool // execute batches in order
+	stopErr bool // stop ordered batches at the first failure
+
+	okey func(context.Context, *Request) string // derive ordering keys
+
+	// Service discovery (see ServerOptions.Discover).
+	discover *OpenRPCInfo     // if non-nil, enable rpc.discover
+	annotate func(*MethodDoc) // if set, annotate method descriptions
+
+	// Inbound queue limits (see ServerOptions.MaxQueueRequests).
+	maxQReq   int  // maximum queued requests (0 means unlimited)
+	maxQBytes int  // maximum queued bytes (0 means unlimited)
+	rejectQ   bool // reject rather than block when the queue is full
+	maxBatch  int  // maximum requests per batch (0 means unlimited)
 
 	mu *sync.Mutex // protects the fields below
 
@@ -44,6 +77,13 @@ type Server struct {
 	inq  *list.List      // inbound requests awaiting processing
 	ch   channel.Channel // the channel to the client
 
+	room   *sync.Cond // for signaling space in the inbound queue
+	qreqs  int        // number of requests received and not yet completed
+	qbytes int        // number of encoded bytes received and not yet completed
+
+	nbusy int           // number of batches dispatched but not yet delivered
+	drain chan struct{} // if non-nil, closed when the server is idle (see Shutdown)
+
 	// For each request ID currently in-flight, this map carries a cancel
 	// function attached to the context that was sent to the handler.
 	used map[string]context.CancelFunc
@@ -52,6 +92,20 @@ type Server struct {
 	// waiting for its reply.
 	call   map[string]*Response
 	callID int64
+
+	// For each subscription currently active, this map carries its state.
+	// Subscriptions whose ID has not yet been delivered to the client are
+	// also recorded in subreq, by the ID of the request that created them.
+	subs   map[string]*Subscription
+	subreq map[string]*Subscription
+	subID  int64
+
+	// For each ordering key with requests pending, this map carries a channel
+	// that is closed when the most recently received of them completes. It has
+	// its own lock, because a task releases its key while another goroutine
+	// may hold s.mu in dispatch, waiting for a notification behind that task.
+	kmu  sync.Mutex
+	keys map[string]chan struct{}
 }
 
 // NewServer returns a new unstarted server that will dispatch incoming
@@ -66,26 +120,60 @@ func NewServer(mux Assigner, opts *ServerOptions) *Server {
 		panic("nil assigner")
 	}
 	dc, exp := opts.decodeContext()
+	nreq, nbytes, reject := opts.queueLimits()
+	limits := opts.methodLimits()
+	limsem := make(map[string]*semaphore.Weighted)
+	for key, n := range limits {
+		limsem[key] = semaphore.NewWeighted(int64(n))
+	}
 	s := &Server{
 		mux:     mux,
 		sem:     semaphore.NewWeighted(opts.concurrency()),
+		limits:  limits,
+		limsem:  limsem,
+		limcode: opts.methodLimitCode(),
+
+		ordered: opts.orderedBatches(),
+		stopErr: opts.stopBatchOnError(),
+		okey:    opts.orderingKey(),
+
+		discover: opts.discoverInfo(),
+		annotate: opts.annotateMethod(),
+
+		maxQReq:   nreq,
+		maxQBytes: nbytes,
+		rejectQ:   reject,
+		maxBatch:  opts.maxBatchSize(),
+
 		allow1:  opts.allowV1(),
 		allowP:  opts.allowPush(),
+		cancelP: opts.cancelCallbacks(),
 		log:     opts.logger(),
 		rpcLog:  opts.rpcLog(),
 		dectx:   dc,
 		ckreq:   opts.checkRequest(),
+		icept:   opts.interceptors(),
 		expctx:  exp,
 		mu:      new(sync.Mutex),
 		metrics: opts.metrics(),
 		start:   opts.startTime(),
 		builtin: opts.allowBuiltin(),
+		recov:   opts.recoverPanics(),
+		deftime: opts.requestTimeout(),
+		maxtime: opts.maxRequestTimeout(),
+		mtimes:  opts.methodTimeouts(),
+		pstack:  opts.panicStack(),
 		inq:     list.New(),
 		used:    make(map[string]context.CancelFunc),
 		call:    make(map[string]*Response),
 		callID:  1,
+		subs:    make(map[string]*Subscription),
+		subreq:  make(map[string]*Subscription),
+		subID:   1,
+		keys:    make(map[string]chan struct{}),
 	}
 	s.work = sync.NewCond(s.mu)
+	s.room = sync.NewCond(s.mu)
 	return s
 }
 
@@ -106,6 +194,7 @@ func (s *Server) Start(c channel.Channel) *Server {
 
 	// Reset all the I/O structures and start up the workers.
 	s.err = nil
+	s.drain = nil
 
 	// s.wg waits for the maintenance goroutines for receiving input and
 	// processing the request queue. In addition, each request in flight adds a
@@ -170,11 +259,28 @@ func (s *Server) nextRequest() (func() error, error) {
 	}
 	ch := s.ch // capture
 
-	next := s.inq.Remove(s.inq.Front()).(jmessages)
-	s.log("Processing %d requests", len(next))
+	next := s.inq.Remove(s.inq.Front()).(*qbatch)
+	s.nbusy++
+	s.log("Processing %d requests", len(next.msgs))
 
 	// Construct a dispatcher to run the handlers outside the lock.
-	return s.dispatch(next, ch), nil
+	run := s.dispatch(next.msgs, ch)
+	return func() error {
+		defer s.finish(next)
+		return run()
+	}, nil
+}
+
+// finish records the completion of a batch of requests, releasing its space
+// in the inbound queue.
+func (s *Server) finish(b *qbatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qreqs -= len(b.msgs)
+	s.qbytes -= b.size
+	s.room.Broadcast()
+	s.nbusy--
+	s.checkIdle()
 }
 
 // dispatch constructs a function that invokes each of the specified tasks.
@@ -188,8 +294,10 @@ func (s *Server) nextRequest() (func() error, error) {
 func (s *Server) dispatch(next jmessages, ch channel.Sender) func() error {
 	// Resolve all the task handlers or record errors.
 	start := time.Now()
+	next, ordered, stop := s.batchOrder(next)
 	tasks := s.checkAndAssign(next)
 	last := len(tasks) - 1
+	s.orderTasks(tasks)
 
 	// s.nbar counts the number of notifications that have been issued and are
 	// not yet complete. Before issuing any tasks in this batch, wait for all
@@ -201,9 +309,14 @@ func (s *Server) dispatch(next jmessages, ch channel.Sender) func() error {
 
 	return func() error {
 		var wg sync.WaitGroup
+		var failed bool // whether an earlier task in the batch failed
 		for i, t := range tasks {
 			if t.err != nil {
+				failed = true
 				continue // nothing to do here; this task has already failed
+			} else if failed && stop {
+				s.skip(t)
+				continue
 			}
 			t := t
 
@@ -213,9 +326,26 @@ func (s *Server) dispatch(next jmessages, ch channel.Sender) func() error {
 				if t.hreq.IsNotification() {
 					defer s.nbar.Done()
 				}
-				t.val, t.err = s.invoke(t.ctx, t.m, t.hreq)
+				defer s.release(t)
+				if err := t.waitTurn(); err != nil {
+					t.err = err
+				} else {
+					t.val, t.err = s.invoke(t.ctx, t.m, t.hreq)
+				}
+				if t.cancel != nil {
+					// The server imposed a timeout on this request; if it
+					// expired, report that regardless of the handler result.
+					if t.ctx.Err() == context.DeadlineExceeded {
+						s.metrics.Count("rpc.timeouts", 1)
+						t.val, t.err = nil, code.DeadlineExceeded.Err()
+					}
+					t.cancel()
+				}
 			}
-			if i < last {
+			if ordered {
+				run()
+				failed = failed || t.err != nil
+			} else if i < last {
 				go run()
 			} else {
 				run()
@@ -228,6 +358,58 @@ func (s *Server) dispatch(next jmessages, ch channel.Sender) func() error {
 	}
 }
 
+// skip marks t as skipped, without executing its handler.
+func (s *Server) skip(t *task) {
+	s.metrics.Count("rpc.skipped", 1)
+	t.err = errSkipped
+	if t.hreq.IsNotification() {
+		s.nbar.Done()
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+	s.release(t)
+}
+
+// orderTasks assigns ordering keys to the valid tasks in ts, so that each
+// task waits for the completion of the last task previously received with the
+// same key. The caller must hold s.mu, so that batches are ordered as they
+// are dispatched.
+func (s *Server) orderTasks(ts tasks) {
+	if s.okey == nil {
+		return
+	}
+	s.kmu.Lock()
+	defer s.kmu.Unlock()
+	for _, t := range ts {
+		if t.err != nil {
+			continue
+		}
+		key := s.okey(t.ctx, t.hreq)
+		if key == "" {
+			continue
+		}
+		t.key, t.after, t.done = key, s.keys[key], make(chan struct{})
+		s.keys[key] = t.done
+	}
+}
+
+// release marks the completion of t for the purposes of its ordering key, if
+// it has one, permitting the next task with the same key to run. It must not
+// acquire s.mu (see the keys field).
+func (s *Server) release(t *task) {
+	if t.done == nil {
+		return
+	}
+	s.kmu.Lock()
+	defer s.kmu.Unlock()
+	close(t.done)
+	if s.keys[t.key] == t.done {
+		delete(s.keys, t.key)
+	}
+	t.done = nil
+}
+
 // deliver cleans up completed responses and arranges their replies (if any) to
 // be sent back to the client.
 func (s *Server) deliver(rsps jmessages, ch channel.Sender, elapsed time.Duration) error {
@@ -245,6 +427,9 @@ func (s *Server) deliver(rsps jmessages, ch channel.Sender, elapsed time.Duratio
 
 	nw, err := encode(ch, rsps)
 	s.metrics.CountAndSetMax("rpc.bytesWritten", int64(nw))
+	if err == nil {
+		s.activateSubscriptions(rsps)
+	}
 	return err
 }
 
@@ -261,7 +446,7 @@ func (s *Server) checkAndAssign(next jmessages) tasks {
 		}
 		if req.err != nil {
 			t.err = req.err // deferred validation error
-		} else if id := string(fid); id != "" && s.used[id] != nil {
+		} else if id := string(fid); id != "" && req.isRequestOrNotification() && s.used[id] != nil {
 			t.err = Errorf(code.InvalidRequest, "duplicate request id %q", id)
 		} else if !s.versionOK(req.V) {
 			t.err = ErrInvalidVersion
@@ -283,6 +468,9 @@ func (s *Server) checkAndAssign(next jmessages) tasks {
 		if t.err != nil {
 			s.log("Task error: %v", t.err)
 			s.metrics.Count("rpc.errors", 1)
+			if t.cancel != nil {
+				t.cancel() // release the timer for a task that will not run
+			}
 		}
 		ts = append(ts, t)
 	}
@@ -307,6 +495,13 @@ func (s *Server) setContext(t *task, id string) bool {
 
 	t.ctx = context.WithValue(base, inboundRequestKey{}, t.hreq)
 
+	// Apply the server's timeout for this method, if there is one. Since the
+	// handler may ignore its context, remember that we did so.
+	if d := s.timeout(t.hreq.method); d > 0 {
+		ctx, cancel := context.WithTimeout(t.ctx, d)
+		t.ctx, t.cancel = ctx, cancel
+	}
+
 	// Store the cancellation for a request that needs a reply, so that we can
 	// respond to rpc.cancel requests.
 	if id != "" {
@@ -321,13 +516,26 @@ func (s *Server) setContext(t *task, id string) bool {
 // the return value into JSON if there is one.
 func (s *Server) invoke(base context.Context, h Handler, req *Request) (json.RawMessage, error) {
 	ctx := context.WithValue(base, serverKey{}, s)
+	if lim := s.limiter(req.Method()); lim == nil {
+		// no per-method limit applies
+	} else if s.limcode != 0 {
+		if !lim.TryAcquire(1) {
+			s.metrics.Count("rpc.limitRejected", 1)
+			return nil, Errorf(s.limcode, "too many concurrent requests for %q", req.Method())
+		}
+		defer lim.Release(1)
+	} else if err := lim.Acquire(ctx, 1); err != nil {
+		return nil, err
+	} else {
+		defer lim.Release(1)
+	}
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		return nil, err
 	}
 	defer s.sem.Release(1)
 
 	s.rpcLog.LogRequest(ctx, req)
-	v, err := h.Handle(ctx, req)
+	v, err := s.handle(ctx, s.intercept(h), req)
 	if err != nil {
 		if req.IsNotification() {
 			s.log("Discarding error from notification to %q: %v", req.Method(), err)
@@ -338,11 +546,97 @@ func (s *Server) invoke(base context.Context, h Handler, req *Request) (json.Raw
 	return json.Marshal(v)
 }
 
+// handle invokes h with the given context and request. If s is configured to
+// recover panics, a panic in h is reported as an InternalError.
+func (s *Server) handle(ctx context.Context, h Handler, req *Request) (v interface{}, err error) {
+	if s.recov {
+		defer func() {
+			if p := recover(); p != nil {
+				stack := debug.Stack()
+				s.metrics.Count("rpc.panics", 1)
+				s.log("Recovered panic in handler for %q: %v\n%s", req.Method(), p, stack)
+
+				var data interface{}
+				if s.pstack {
+					data = panicData{Panic: fmt.Sprint(p), Stack: string(stack)}
+				}
+				v, err = nil, DataErrorf(code.InternalError, data, "handler panicked: %v", p)
+			}
+		}()
+	}
+	return h.Handle(ctx, req)
+}
+
+// panicData is the error data reported for a recovered handler panic when
+// stack traces are enabled.
+type panicData struct {
+	Panic string `json:"panic"`
+	Stack string `json:"stack"`
+}
+
+// intercept returns a Handler that passes requests through the interceptors
+// of s before delivering them to h.
+func (s *Server) intercept(h Handler) Handler {
+	for i := len(s.icept) - 1; i >= 0; i-- {
+		icept, next := s.icept[i], h
+		h = methodFunc(func(ctx context.Context, req *Request) (interface{}, error) {
+			return icept(ctx, req, next)
+		})
+	}
+	return h
+}
+
+// limiter returns the semaphore governing the per-method concurrency limit
+// for the specified method, or nil if no limit applies. If several keys match
+// the method name, the longest match is chosen.
+func (s *Server) limiter(method string) (lim *semaphore.Weighted) {
+	lookupMethod(method, func(key string) bool {
+		lim = s.limsem[key]
+		return lim != nil
+	})
+	return
+}
+
+// timeout returns the timeout to apply to a request for the specified method,
+// or 0 if no timeout applies. A timeout set for the method in MethodTimeout
+// takes precedence over the default, and the result is capped by the maximum
+// timeout, if one is set.
+func (s *Server) timeout(method string) time.Duration {
+	d := s.deftime
+	lookupMethod(method, func(key string) bool {
+		v, ok := s.mtimes[key]
+		if ok {
+			d = v
+		}
+		return ok
+	})
+	if s.maxtime > 0 && (d <= 0 || d > s.maxtime) {
+		d = s.maxtime
+	}
+	return d
+}
+
+// lookupMethod calls find with each key that could apply to the specified
+// method name, from most to least specific, until find reports true. The keys
+// are the method name itself, followed by each prefix of the method name with
+// a "*" appended, longest first.
+func lookupMethod(method string, find func(key string) bool) {
+	if find(method) {
+		return
+	}
+	for i := len(method); i >= 0; i-- {
+		if find(method[:i] + "*") {
+			return
+		}
+	}
+}
+
 // ServerInfo returns an atomic snapshot of the current server info for s.
 func (s *Server) ServerInfo() *ServerInfo {
 	info := &ServerInfo{
 		Methods:     s.mux.Names(),
 		UsesContext: s.expctx,
+		Limits:      make(map[string]int),
 		StartTime:   s.start,
 		Counter:     make(map[string]int64),
 		MaxValue:    make(map[string]int64),
@@ -353,6 +647,9 @@ func (s *Server) ServerInfo() *ServerInfo {
 		MaxValue: info.MaxValue,
 		Label:    info.Label,
 	})
+	for key, n := range s.limits {
+		info.Limits[key] = n
+	}
 	return info
 }
 
@@ -372,9 +669,16 @@ func (s *Server) Notify(ctx context.Context, method string, params interface{})
 }
 
 // Callback posts a single server-side call to the client. It blocks until a
-// reply is received or the client connection terminates.  A successful
-// callback reports a nil error and a non-nil response. Errors returned by the
-// client have concrete type *jrpc2.Error.
+// reply is received, ctx ends, or the client connection terminates.  A
+// successful callback reports a nil error and a non-nil response. Errors
+// returned by the client have concrete type *jrpc2.Error.
+//
+// If ctx ends before the client replies, Callback abandons the call and
+// reports the error from ctx; a later reply from the client is discarded. If
+// the CancelCallbacks server option is true, the server also sends an
+// "rpc.cancel" notification for the call to the client. If the client
+// connection terminates before the client replies, Callback reports an error
+// with code.Cancelled.
 //
 // This is a non-standard extension of JSON-RPC, and may not be supported by
 // all clients. Unless s was constructed with the AllowPush option set true,
@@ -389,7 +693,15 @@ func (s *Server) Callback(ctx context.Context, method string, params interface{}
 	if err != nil {
 		return nil, err
 	}
-	rsp.wait()
+	select {
+	case raw := <-rsp.ch:
+		rsp.complete(raw)
+	case <-ctx.Done():
+		if s.abandonCall(rsp.id) {
+			return nil, ctx.Err()
+		}
+		rsp.wait() // the reply arrived before the call was abandoned
+	}
 	if err := rsp.Error(); err != nil {
 		return nil, filterError(err)
 	}
@@ -435,9 +747,35 @@ func (s *Server) pushReq(ctx context.Context, wantID bool, method string, params
 	}})
 	s.metrics.CountAndSetMax("rpc.bytesWritten", int64(nw))
 	s.metrics.Count("rpc."+kind+"s", 1)
+	if err != nil && rsp != nil {
+		delete(s.call, rsp.id)
+	}
 	return rsp, err
 }
 
+// abandonCall removes the pending callback with the given ID, and reports
+// whether it was still pending. If so, and if the server is configured to
+// cancel callbacks, the client is sent an rpc.cancel notification for it.
+func (s *Server) abandonCall(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.call[id]; !ok {
+		return false
+	}
+	delete(s.call, id)
+	s.metrics.Count("rpc.callbacksAbandoned", 1)
+	s.log("Abandoned server call %s", id)
+	if s.cancelP && s.ch != nil {
+		bits, _ := json.Marshal([]json.RawMessage{json.RawMessage(id)})
+		nw, err := encode(s.ch, jmessages{{V: Version, M: rpcCancel, P: bits}})
+		s.metrics.CountAndSetMax("rpc.bytesWritten", int64(nw))
+		if err != nil {
+			s.log("Error sending cancellation for call %s: %v", id, err)
+		}
+	}
+	return true
+}
+
 // Stop shuts down the server. It is safe to call this method multiple times or
 // from concurrent goroutines; it will only take effect once.
 func (s *Server) Stop() {
@@ -446,6 +784,55 @@ func (s *Server) Stop() {
 	s.stop(errServerStopped)
 }
 
+// Shutdown gracefully shuts down the server. Unlike Stop, Shutdown does not
+// immediately close the channel: Requests already received from the client are
+// allowed to complete and their responses are delivered before the server
+// stops. Requests received after Shutdown is called are rejected with an
+// error. If ctx ends before the pending work completes, Shutdown stops the
+// server as Stop does, cancelling any requests still in flight, and returns
+// the error from ctx.
+//
+// Once Shutdown returns, the server status reports Stopped, as for Stop. It is
+// safe to call Shutdown multiple times or concurrently with Stop.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.ch == nil {
+		s.mu.Unlock()
+		return nil // nothing is running
+	}
+	if s.drain == nil {
+		s.log("Server signaled to shut down")
+		s.drain = make(chan struct{})
+	}
+	idle := s.drain
+	s.checkIdle()
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.log("Shutdown ended before server was idle: %v", err)
+	}
+	s.Stop()
+	return err
+}
+
+// checkIdle signals a pending Shutdown if there is no more work in progress.
+// The caller must hold s.mu.
+func (s *Server) checkIdle() {
+	if s.drain == nil || s.inq.Len() != 0 || s.nbusy != 0 {
+		return
+	}
+	select {
+	case <-s.drain:
+		// already closed
+	default:
+		close(s.drain)
+	}
+}
+
 // ServerStatus describes the status of a stopped server.
 type ServerStatus struct {
 	Err error // the error that caused the server to stop (nil on success)
@@ -499,7 +886,10 @@ func (s *Server) stop(err error) {
 	// TODO(@creachadair): We need better tests for this behaviour.
 	var keep jmessages
 	for cur := s.inq.Front(); cur != nil; cur = s.inq.Front() {
-		for _, req := range cur.Value.(jmessages) {
+		b := cur.Value.(*qbatch)
+		s.qreqs -= len(b.msgs)
+		s.qbytes -= b.size
+		for _, req := range b.msgs {
 			if req.isNotification() {
 				keep = append(keep, req)
 				s.log("Retaining notification %p", req)
@@ -510,9 +900,10 @@ func (s *Server) stop(err error) {
 		s.inq.Remove(cur)
 	}
 	for _, elt := range keep {
-		s.inq.PushBack(jmessages{elt})
+		s.enqueue(jmessages{elt}, 0)
 	}
 	s.work.Broadcast()
+	s.room.Broadcast()
 
 	// Cancel any in-flight requests that made it out of the queue.
 	for id, cancel := range s.used {
@@ -525,6 +916,23 @@ func (s *Server) stop(err error) {
 		panic("s.used is not empty at shutdown")
 	}
 
+	// Fail any callbacks still awaiting a reply from the client.
+	for id, rsp := range s.call {
+		rsp.ch <- &jmessage{
+			ID: json.RawMessage(id),
+			E:  &Error{code: code.Cancelled, message: "client connection closed"},
+		}
+		delete(s.call, id)
+	}
+
+	// End all the active subscriptions.
+	for id := range s.subs {
+		s.unsubscribe(id)
+	}
+	for id := range s.subreq {
+		delete(s.subreq, id)
+	}
+
 	s.err = err
 	s.ch = nil
 }
@@ -541,7 +949,11 @@ func (s *Server) read(ch channel.Receiver) {
 		var derr error
 		bits, err := ch.Recv()
 		s.metrics.CountAndSetMax("rpc.bytesRead", int64(len(bits)))
-		if err == nil || (err == io.EOF && len(bits) != 0) {
+		var big *channel.MessageTooLargeError
+		if errors.As(err, &big) {
+			s.metrics.Count("rpc.tooLarge", 1)
+			err, derr = nil, Errorf(code.InvalidRequest, "request message of %d bytes exceeds limit of %d", big.Size, big.Limit)
+		} else if err == nil || (err == io.EOF && len(bits) != 0) {
 			err = nil
 			derr = in.parseJSON(bits)
 			s.metrics.Count("rpc.requests", int64(len(in)))
@@ -555,15 +967,105 @@ func (s *Server) read(ch channel.Receiver) {
 			s.pushError(derr)
 		} else if len(in) == 0 {
 			s.pushError(Errorf(code.InvalidRequest, "empty request batch"))
+		} else if s.maxBatch > 0 && len(in) > s.maxBatch {
+			s.metrics.Count("rpc.tooLarge", 1)
+			s.pushError(Errorf(code.InvalidRequest, "request batch of %d requests exceeds limit of %d", len(in), s.maxBatch))
 		} else {
 			s.log("Received %d new requests", len(in))
-			s.inq.PushBack(in)
-			s.work.Broadcast()
+			if s.drain != nil {
+				// The server is shutting down: Reject new requests, but still
+				// deliver replies to pending push-calls.
+				for _, req := range in {
+					if req.err == nil && req.isRequestOrNotification() {
+						req.err = errShuttingDown
+					}
+				}
+			} else if !s.admit(len(in), len(bits)) {
+				in = s.rejectOverload(in)
+			}
+			if s.ch == nil {
+				s.mu.Unlock()
+				return // the server stopped while we were waiting
+			} else if len(in) != 0 {
+				s.enqueue(in, len(bits))
+			}
 		}
 		s.mu.Unlock()
 	}
 }
 
+// A qbatch is a batch of inbound messages awaiting completion.
+type qbatch struct {
+	msgs jmessages
+	size int // encoded size in bytes
+}
+
+// enqueue adds a batch of messages having the given encoded size to the
+// inbound queue and signals the dispatcher. The caller must hold s.mu.
+func (s *Server) enqueue(msgs jmessages, size int) {
+	s.inq.PushBack(&qbatch{msgs: msgs, size: size})
+	s.qreqs += len(msgs)
+	s.qbytes += size
+	s.metrics.SetMaxValue("rpc.queueRequests", int64(s.qreqs))
+	s.work.Broadcast()
+}
+
+// admit reports whether a batch of nreq requests with the given encoded size
+// may be added to the inbound queue. If the server is configured to block
+// when the queue is full, admit waits until there is room or the server
+// stops, and reports true. The caller must hold s.mu.
+//
+// Requests occupy space in the queue from when they are received until their
+// responses are delivered. A batch is always admitted if the queue is empty.
+func (s *Server) admit(nreq, size int) bool {
+	for s.ch != nil && s.qreqs != 0 && s.overLimit(nreq, size) {
+		if s.rejectQ {
+			return false
+		}
+		s.log("Inbound queue is full; waiting for space")
+		s.room.Wait()
+	}
+	return true
+}
+
+// overLimit reports whether adding nreq requests with the given encoded size
+// would exceed the inbound queue limits. The caller must hold s.mu.
+func (s *Server) overLimit(nreq, size int) bool {
+	return (s.maxQReq > 0 && s.qreqs+nreq > s.maxQReq) ||
+		(s.maxQBytes > 0 && s.qbytes+size > s.maxQBytes)
+}
+
+// rejectOverload replies to each request in msgs with an error reporting that
+// the server is overloaded. It returns the messages that must still be
+// queued, namely replies to pending push-calls. The caller must hold s.mu.
+func (s *Server) rejectOverload(msgs jmessages) jmessages {
+	var keep, rsps jmessages
+	for _, req := range msgs {
+		if !req.isRequestOrNotification() && req.err == nil {
+			keep = append(keep, req)
+			continue
+		}
+		s.metrics.Count("rpc.rejected", 1)
+		if id := fixID(req.ID); id != nil {
+			rsps = append(rsps, &jmessage{
+				V:     Version,
+				ID:    id,
+				E:     &Error{code: code.Overloaded, message: "server overloaded"},
+				batch: req.batch,
+			})
+		}
+	}
+	s.log("Rejected %d requests; inbound queue is full", len(msgs)-len(keep))
+	if len(rsps) != 0 {
+		nw, err := encode(s.ch, rsps)
+		s.metrics.CountAndSetMax("rpc.bytesWritten", int64(nw))
+		if err != nil {
+			s.log("Writing overload response: %v", err)
+		}
+	}
+	return keep
+}
+
 // ServerInfo is the concrete type of responses from the rpc.serverInfo method.
 type ServerInfo struct {
 	// The list of method names exported by this server.
@@ -572,6 +1074,9 @@ type ServerInfo struct {
 	// Whether this server understands context wrappers.
 	UsesContext bool `json:"usesContext"`
 
+	// Per-method concurrency limits, keyed by method name or prefix.
+	Limits map[string]int `json:"limits,omitempty"`
+
 	// Metric values defined by the evaluation of methods.
 	Counter  map[string]int64  `json:"counters,omitempty"`
 	MaxValue map[string]int64  `json:"maxValue,omitempty"`
@@ -590,6 +1095,13 @@ func (s *Server) assign(ctx context.Context, name string) Handler {
 			return methodFunc(s.handleRPCServerInfo)
 		case rpcCancel:
 			return methodFunc(s.handleRPCCancel)
+		case rpcUnsubscribe:
+			return methodFunc(s.handleRPCUnsubscribe)
+		case rpcDiscover:
+			if s.discover != nil {
+				return methodFunc(s.handleRPCDiscover)
+			}
+			return nil
 		default:
 			return nil // reserved
 		}
@@ -644,12 +1156,31 @@ func (s *Server) versionOK(v string) bool {
 type task struct {
 	m Handler // the assigned handler (after assignment)
 
-	ctx   context.Context // the context passed to the handler
-	hreq  *Request        // the request passed to the handler
-	batch bool            // whether the request was part of a batch
+	ctx    context.Context    // the context passed to the handler
+	cancel context.CancelFunc // if set, releases a server-imposed timeout
+	hreq   *Request           // the request passed to the handler
+	batch  bool               // whether the request was part of a batch
 
 	val json.RawMessage // the result value (when complete)
 	err error           // the error value (when complete)
+
+	key   string        // the ordering key, if any
+	after chan struct{} // if non-nil, closed when t may run
+	done  chan struct{} // if non-nil, closed when t is complete
+}
+
+// waitTurn blocks until the tasks preceding t with the same ordering key have
+// completed, or until the context of t ends.
+func (t *task) waitTurn() error {
+	if t.after == nil {
+		return nil
+	}
+	select {
+	case <-t.after:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
 }
 
 type tasks []*task
@@ -10,8 +10,40 @@ import (
 const (
 	rpcServerInfo = "rpc.serverInfo"
 	rpcCancel     = "rpc.cancel"
+	rpcProgress   = "rpc.progress"
+	rpcDiscover   = "rpc.discover"
+
+	rpcEvent       = "rpc.event"
+	rpcUnsubscribe = "rpc.unsubscribe"
+
+	rpcOrdered = "rpc.ordered"
 )
 
+// orderedParams is the encoding of the parameters to an rpc.ordered
+// notification, that marks a batch for ordered execution.
+type orderedParams struct {
+	StopOnError bool `json:"stopOnError,omitempty"`
+}
+
+// batchOrder reports whether the requests in next should be executed in
+// order, and if so whether execution should stop at the first failure. Stop
+// is reported only for an ordered batch. If the batch begins with an
+// rpc.ordered marker, the marker is removed from the batch.
+func (s *Server) batchOrder(next jmessages) (_ jmessages, ordered, stop bool) {
+	ordered, stop = s.ordered, s.stopErr
+	if !s.builtin || len(next) == 0 {
+		return next, ordered, ordered && stop
+	}
+	if m := next[0]; m.batch && m.err == nil && m.M == rpcOrdered && m.isNotification() {
+		var opts orderedParams
+		if len(m.P) != 0 && json.Unmarshal(m.P, &opts) != nil {
+			s.log("Invalid parameters for %s: %s", rpcOrdered, string(m.P))
+		}
+		return next[1:], true, stop || opts.StopOnError
+	}
+	return next, ordered, ordered && stop
+}
+
 // Handle the special rpc.cancel notification, that requests cancellation of a
 // set of pending methods. This only works if issued as a notification.
 func (s *Server) handleRPCCancel(ctx context.Context, req *Request) (interface{}, error) {
@@ -55,3 +87,10 @@ func RPCServerInfo(ctx context.Context, cli *Client) (result *ServerInfo, err er
 	err = cli.CallResult(ctx, rpcServerInfo, nil, &result)
 	return
 }
+
+// progressParams is the encoding of the parameters to an rpc.progress
+// notification sent by the server to the client.
+type progressParams struct {
+	ID    json.RawMessage `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
@@ -0,0 +1,345 @@
+package jrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// ErrSubscriptionClosed is reported by the Notify method of a Subscription
+// that has ended.
+var ErrSubscriptionClosed = errors.New("subscription is closed")
+
+// A Subscription is a stream of events pushed by a server to a client.
+//
+// A method handler creates a subscription by calling NewSubscription, and
+// returns the ID of the subscription to the client as its result. The server
+// may then push events to the client using the Notify method of the
+// subscription. Each event is sent to the client as an "rpc.event"
+// notification whose parameters are an object whose "id" field is the ID of
+// the subscription and whose "value" field is the encoded event.
+//
+// A subscription ends when the server calls its Close method, when the client
+// unsubscribes by calling the built-in "rpc.unsubscribe" method, or when the
+// connection to the client closes. Subscriptions require server push to be
+// enabled (see AllowPush in ServerOptions).
+type Subscription struct {
+	s     *Server
+	id    string
+	ready chan struct{} // closed when the client has received the ID
+	ctx   context.Context
+	end   context.CancelFunc
+}
+
+// NewSubscription creates a new subscription for the inbound request
+// associated with ctx, which must be the context passed to a handler by
+// *jrpc2.Server. It reports ErrPushUnsupported if server push is not enabled,
+// and an error if the inbound request is a notification.
+//
+// Events sent by Notify are not delivered until the response to the inbound
+// request has been sent to the client. If the request fails, the
+// subscription is closed.
+func NewSubscription(ctx context.Context) (*Subscription, error) {
+	req := InboundRequest(ctx)
+	if req == nil || req.IsNotification() {
+		return nil, errors.New("no request ID for subscription")
+	}
+	s := ctx.Value(serverKey{}).(*Server)
+	if !s.allowP {
+		return nil, ErrPushUnsupported
+	}
+	return s.newSubscription(req.ID())
+}
+
+// ID returns the subscription ID for sub. This is the value the handler should
+// return to the client as its result.
+func (sub *Subscription) ID() string { return sub.id }
+
+// Done returns a channel that is closed when sub has ended.
+func (sub *Subscription) Done() <-chan struct{} { return sub.ctx.Done() }
+
+// Notify pushes an event to the client for sub. It blocks until the client has
+// been sent the subscription ID, sub ends, or ctx ends. If sub has ended, it
+// reports ErrSubscriptionClosed.
+func (sub *Subscription) Notify(ctx context.Context, value interface{}) error {
+	select {
+	case <-sub.ready:
+	case <-sub.ctx.Done():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if sub.ctx.Err() != nil {
+		return ErrSubscriptionClosed
+	}
+	bits, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return sub.s.Notify(ctx, rpcEvent, eventParams{ID: sub.id, Value: bits})
+}
+
+// Close ends sub, and informs the client that no further events will be sent.
+// It is safe to call Close more than once; subsequent calls do nothing.
+func (sub *Subscription) Close() error {
+	if !sub.s.endSubscription(sub.id) {
+		return nil
+	}
+	select {
+	case <-sub.ready:
+		return sub.s.Notify(context.Background(), rpcEvent, eventParams{ID: sub.id, Done: true})
+	default:
+		return nil // the client never learned of this subscription
+	}
+}
+
+// newSubscription registers a new subscription for the request with the given
+// ID.
+func (s *Server) newSubscription(reqID string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		return nil, ErrConnClosed
+	}
+	id := strconv.FormatInt(s.subID, 10)
+	s.subID++
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &Subscription{
+		s:     s,
+		id:    id,
+		ready: make(chan struct{}),
+		ctx:   ctx,
+		end:   cancel,
+	}
+	s.subs[id] = sub
+	s.subreq[reqID] = sub
+	s.log("Created subscription %q for request %s", id, reqID)
+	return sub, nil
+}
+
+// endSubscription ends the subscription with the given ID, and reports whether
+// it was active.
+func (s *Server) endSubscription(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unsubscribe(id)
+}
+
+// unsubscribe ends the subscription with the given ID, and reports whether it
+// was active. The caller must hold s.mu.
+func (s *Server) unsubscribe(id string) bool {
+	sub, ok := s.subs[id]
+	if ok {
+		sub.end()
+		delete(s.subs, id)
+		s.log("Ended subscription %q", id)
+	}
+	return ok
+}
+
+// activateSubscriptions marks the subscriptions created by the requests in
+// rsps as ready for delivery, once their responses have been sent. If a
+// request failed, its subscription is ended. The caller must hold s.mu.
+func (s *Server) activateSubscriptions(rsps jmessages) {
+	for _, rsp := range rsps {
+		id := string(rsp.ID)
+		if sub, ok := s.subreq[id]; ok {
+			delete(s.subreq, id)
+			if rsp.E != nil {
+				s.unsubscribe(sub.id)
+			} else {
+				close(sub.ready)
+			}
+		}
+	}
+}
+
+// Handle the special rpc.unsubscribe method, that ends a set of subscriptions.
+// It reports whether any of the named subscriptions were active.
+func (s *Server) handleRPCUnsubscribe(ctx context.Context, req *Request) (interface{}, error) {
+	var ids []string
+	if err := req.UnmarshalParams(&ids); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ok bool
+	for _, id := range ids {
+		if s.unsubscribe(id) {
+			ok = true
+		}
+	}
+	return ok, nil
+}
+
+// eventParams is the encoding of the parameters to an rpc.event notification
+// sent by the server to the client.
+type eventParams struct {
+	ID    string          `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Done  bool            `json:"done,omitempty"`
+}
+
+// A Subscriber receives the events for a subscription created by the Subscribe
+// method of a *jrpc2.Client.
+type Subscriber struct {
+	c      *Client
+	id     string
+	events chan json.RawMessage
+	quit   chan struct{} // closed when the client unsubscribes
+	once   sync.Once
+
+	mu    sync.Mutex
+	queue []json.RawMessage // events not yet delivered
+	done  bool              // no further events will be queued
+	wake  chan struct{}     // signals the delivery goroutine
+}
+
+// Subscribe calls the specified method, which must create a subscription on
+// the server (see NewSubscription) and return its ID. If it succeeds, events
+// pushed by the server for the subscription are delivered to the channel
+// returned by the Events method of the resulting Subscriber.
+func (c *Client) Subscribe(ctx context.Context, method string, params interface{}) (*Subscriber, error) {
+	sub := &Subscriber{
+		c:      c,
+		events: make(chan json.RawMessage),
+		quit:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+	go sub.run()
+
+	// The client registers the subscription when the response is received,
+	// so that events following the response are not lost.
+	if _, err := c.Call(context.WithValue(ctx, subscriberKey{}, sub), method, params); err != nil {
+		sub.stop()
+		return nil, err
+	} else if sub.id == "" {
+		sub.stop()
+		return nil, errors.New("invalid subscription ID")
+	}
+	return sub, nil
+}
+
+type subscriberKey struct{}
+
+// ID returns the subscription ID for s.
+func (s *Subscriber) ID() string { return s.id }
+
+// Events returns a channel that delivers the value of each event pushed by the
+// server for s. The channel is closed after the subscription ends. If the
+// subscription is ended by the server, all events sent before it ended are
+// delivered before the channel closes.
+func (s *Subscriber) Events() <-chan json.RawMessage { return s.events }
+
+// Unsubscribe ends the subscription and informs the server, by calling the
+// built-in "rpc.unsubscribe" method. Any events not yet delivered are
+// discarded, and the channel returned by Events is closed.
+func (s *Subscriber) Unsubscribe(ctx context.Context) error {
+	s.c.mu.Lock()
+	delete(s.c.subs, s.id)
+	s.c.mu.Unlock()
+	s.stop()
+	_, err := s.c.Call(ctx, rpcUnsubscribe, []string{s.id})
+	return err
+}
+
+// push queues an event for delivery.
+func (s *Subscriber) push(value json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.done {
+		s.queue = append(s.queue, value)
+		s.signal()
+	}
+}
+
+// finish marks the end of events for s. Events already queued are delivered.
+func (s *Subscriber) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.signal()
+}
+
+// stop ends delivery of events for s, discarding any that are queued.
+func (s *Subscriber) stop() {
+	s.finish()
+	s.once.Do(func() { close(s.quit) })
+}
+
+// signal wakes the delivery goroutine. The caller must hold s.mu.
+func (s *Subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued events to the events channel until the subscription
+// ends, then closes the channel.
+func (s *Subscriber) run() {
+	defer close(s.events)
+	for {
+		select {
+		case <-s.wake:
+		case <-s.quit:
+			return
+		}
+		s.mu.Lock()
+		next, done := s.queue, s.done
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, v := range next {
+			select {
+			case s.events <- v:
+			case <-s.quit:
+				return
+			}
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// handleEvent delivers an rpc.event notification from the server to the
+// subscriber it names, if there is one, and reports whether it did so. The
+// caller must hold c.mu.
+func (c *Client) handleEvent(msg *jmessage) bool {
+	if msg.M != rpcEvent {
+		return false
+	}
+	var evt eventParams
+	if err := json.Unmarshal(msg.P, &evt); err != nil {
+		return false
+	}
+	sub := c.subs[evt.ID]
+	if sub == nil {
+		c.log("Discarding event for unknown subscription %q", evt.ID)
+		return true
+	}
+	if evt.Done {
+		delete(c.subs, evt.ID)
+		sub.finish()
+	} else {
+		sub.push(evt.Value)
+	}
+	return true
+}
+
+// registerSubscriber records the subscription ID from a successful response to
+// a subscription request. The caller must hold c.mu.
+func (c *Client) registerSubscriber(sub *Subscriber, rsp *jmessage) {
+	if rsp.E != nil {
+		return
+	}
+	var id string
+	if err := json.Unmarshal(rsp.R, &id); err != nil || id == "" {
+		c.log("Invalid subscription ID %s", string(rsp.R))
+		return
+	}
+	sub.id = id
+	c.subs[id] = sub
+}